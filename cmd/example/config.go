@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/config"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/logger"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
+	"go.uber.org/zap"
+	"reflect"
+	"strings"
+)
+
+// commandLineFlags returns the names of the flags set on the command line
+func commandLineFlags() map[string]bool {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// applyConfig copies the configuration file into the flag variables,
+// the flags set on the command line win
+func applyConfig(cfg *config.Configuration, set map[string]bool) {
+	if len(cfg.Namespaces) > 0 && !set["namespace"] {
+		namespace = strings.Join(cfg.Namespaces, ",")
+	}
+	if cfg.NamespaceSelector != "" && !set["namespace-selector"] {
+		namespaceSelector = cfg.NamespaceSelector
+	}
+	if len(cfg.SelectorLabels) > 0 && !set["selector-labels"] {
+		selectorLabels = strings.Join(cfg.SelectorLabels, ",")
+	}
+	if cfg.Threadiness > 0 && !set["threadiness"] {
+		threadiness = cfg.Threadiness
+	}
+	if cfg.LogLevel != "" && !set["log-level"] {
+		loglevel = cfg.LogLevel
+	}
+	if cfg.Server.Port != "" && !set["port"] {
+		port = cfg.Server.Port
+	}
+	if cfg.Server.WebhookPort != "" && !set["webhook-port"] {
+		webhookPort = cfg.Server.WebhookPort
+	}
+	if cfg.Prometheus.Address != "" && !set["prometheus-address"] {
+		prometheusAddress = cfg.Prometheus.Address
+	}
+}
+
+// configNotifiers adds the providers of the configuration file to the providers
+// configured with flags, a provider configured with flags is never overridden
+func configNotifiers(flagged []notifierSettings, cfg *config.Configuration) []notifierSettings {
+	settings := append([]notifierSettings{}, flagged...)
+	if cfg == nil {
+		return settings
+	}
+
+	for _, n := range cfg.Notifiers {
+		if hasProvider(flagged, n.Provider) {
+			continue
+		}
+		severity := n.MinSeverity
+		if severity == "" {
+			severity = notifier.SeverityInfo
+		}
+		settings = append(settings, notifierSettings{
+			provider: n.Provider,
+			url:      n.URL,
+			secret:   n.Secret,
+			username: n.Username,
+			channel:  n.Channel,
+			severity: severity,
+			template: n.Template,
+		})
+	}
+	return settings
+}
+
+func hasProvider(settings []notifierSettings, provider string) bool {
+	for _, s := range settings {
+		if s.provider == provider {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadConfig applies the log level and the notifiers of a new configuration,
+// the other settings are only read on startup, the returned configuration keeps
+// the previous notifiers when they could not be built so that they are retried
+func reloadConfig(cfg, previous *config.Configuration, set map[string]bool, level zap.AtomicLevel,
+	flagged []notifierSettings, builder *notifierBuilder, router *notifier.Router, log *zap.SugaredLogger) (*config.Configuration, error) {
+	if !set["log-level"] {
+		next := cfg.LogLevel
+		if next == "" {
+			next = flag.Lookup("log-level").DefValue
+		}
+		if l := logger.ParseLevel(next); l != level.Level() {
+			level.SetLevel(l)
+			log.Infof("Log level changed to %s", l)
+		}
+	}
+
+	if !reflect.DeepEqual(cfg.Namespaces, previous.Namespaces) || cfg.NamespaceSelector != previous.NamespaceSelector || !reflect.DeepEqual(cfg.SelectorLabels, previous.SelectorLabels) ||
+		cfg.Threadiness != previous.Threadiness || cfg.Server != previous.Server || cfg.Prometheus != previous.Prometheus {
+		log.Warn("Changes to namespaces, selector labels, threadiness, server ports and the Prometheus address require a restart")
+	}
+
+	if !reflect.DeepEqual(cfg.Notifiers, previous.Notifiers) {
+		client, err := builder.build(configNotifiers(flagged, cfg))
+		if err != nil {
+			applied := *cfg
+			applied.Notifiers = previous.Notifiers
+			return &applied, fmt.Errorf("keeping the previous notifiers: %w", err)
+		}
+		router.SetNotifier(client)
+	}
+	return cfg, nil
+}
@@ -8,16 +8,29 @@ import (
 	"github.com/go-logr/zapr"
 	clientset "github.com/zhouzhihu/k8s-example-crd/pkg/client/clientset/versioned"
 	informers "github.com/zhouzhihu/k8s-example-crd/pkg/client/informers/externalversions"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/config"
 	"github.com/zhouzhihu/k8s-example-crd/pkg/controller"
 	"github.com/zhouzhihu/k8s-example-crd/pkg/logger"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/metrics"
 	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/prometheus"
 	"github.com/zhouzhihu/k8s-example-crd/pkg/server"
 	"github.com/zhouzhihu/k8s-example-crd/pkg/signals"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/webhook"
 	"go.uber.org/zap"
+	"io/ioutil"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/client-go/transport"
 	"k8s.io/klog/v2"
 	"log"
@@ -32,6 +45,7 @@ var (
 	kubeconfigQPS       int
 	kubeconfigBurst     int
 	namespace           string
+	namespaceSelector   string
 	selectorLabels      string
 	controlLoopInterval time.Duration
 	eventWebhook        string
@@ -40,8 +54,56 @@ var (
 	zapEncoding         string
 	zapReplaceGlobals   bool
 	slackURL            string
+	slackSecret         string
 	slackUser           string
 	slackChannel        string
+	slackSeverity       string
+	rocketURL           string
+	rocketSecret        string
+	rocketUser          string
+	rocketChannel       string
+	rocketSeverity      string
+	teamsURL            string
+	teamsSecret         string
+	teamsSeverity       string
+	discordURL          string
+	discordSecret       string
+	discordUser         string
+	discordSeverity     string
+	genericURL          string
+	genericSecret       string
+	genericTemplate     string
+	genericSeverity     string
+	notifyRetries       int
+	notifyMinBackoff    time.Duration
+	notifyMaxBackoff    time.Duration
+	notifyTimeout       time.Duration
+	notifyDeadLetters   int
+	notifyWorkers       int
+	notifyQueueSize     int
+	notifyOverflow      string
+	notifyBlockTimeout  time.Duration
+	notifyDedupWindow   time.Duration
+	notifyCanaryLimit   int
+	notifyGlobalLimit   int
+	notifyTemplates     string
+
+	enableLeaderElection    bool
+	leaderElectionNamespace string
+	leaseDuration           time.Duration
+	renewDeadline           time.Duration
+	retryPeriod             time.Duration
+
+	port        string
+	webhookPort string
+	tlsCertFile string
+	tlsKeyFile  string
+
+	configFile           string
+	configReloadInterval time.Duration
+
+	prometheusAddress string
+	prometheusTimeout time.Duration
 )
 
 func init() {
@@ -49,25 +111,82 @@ func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.IntVar(&kubeconfigQPS, "kubeconfig-qps", 100, "Set QPS for kubeconfig.")
 	flag.IntVar(&kubeconfigBurst, "kubeconfig-burst", 250, "Set Burst for kubeconfig.")
-	flag.StringVar(&namespace, "namespace", "", "Namespace that example would watch canary object.")
-	flag.StringVar(&selectorLabels, "selector-labels", "", "List of pod labels that Example uses to create pod selectors.")
+	flag.StringVar(&namespace, "namespace", "", "Comma separated list of namespaces that example would watch canary objects in, all namespaces when both the namespaces and the namespace selector are empty.")
+	flag.StringVar(&namespaceSelector, "namespace-selector", "", "Label selector of the namespaces that example would watch canary objects in, e.g. canary.example.app/enabled=true.")
+	flag.StringVar(&selectorLabels, "selector-labels", "app,name,app.kubernetes.io/name", "List of pod labels that Example uses to create pod selectors.")
 	flag.DurationVar(&controlLoopInterval, "control-loop-interval", 10*time.Second, "Kubernetes API sync interval.")
-	flag.StringVar(&eventWebhook, "event-webhook", "", "Webhook for publishing flagger events")
+	flag.StringVar(&eventWebhook, "event-webhook", "", "Webhook for publishing canary events")
 	flag.IntVar(&threadiness, "threadiness", 2, "Worker concurrency.")
-	flag.StringVar(&loglevel, "log-level", "debug", "Log level can be: debug, info, warning, error.")
+	flag.StringVar(&loglevel, "log-level", "debug", "Log level can be: debug, info, warn, error.")
 	flag.StringVar(&zapEncoding, "zap-encoding", "json", "Zap logger encoding.")
 	flag.BoolVar(&zapReplaceGlobals, "zap-replace-globals", false, "Whether to change the logging level of the global zap logger.")
 	flag.StringVar(&slackURL, "slack_url", "", "Slack hook URL.")
+	flag.StringVar(&slackSecret, "slack_secret", "", "Secret holding the Slack hook URL as namespace/name/key, overrides the Slack URL.")
 	flag.StringVar(&slackUser, "slack_user", "", "Slack user name.")
 	flag.StringVar(&slackChannel, "slack_channel", "", "Slack channel.")
+	flag.StringVar(&slackSeverity, "slack_severity", notifier.SeverityInfo, "Minimum severity of the Slack notifications, can be: info, warn, error.")
+	flag.StringVar(&rocketURL, "rocket_url", "", "Rocket.Chat hook URL.")
+	flag.StringVar(&rocketSecret, "rocket_secret", "", "Secret holding the Rocket.Chat hook URL as namespace/name/key, overrides the Rocket.Chat URL.")
+	flag.StringVar(&rocketUser, "rocket_user", "", "Rocket.Chat user name.")
+	flag.StringVar(&rocketChannel, "rocket_channel", "", "Rocket.Chat channel.")
+	flag.StringVar(&rocketSeverity, "rocket_severity", notifier.SeverityInfo, "Minimum severity of the Rocket.Chat notifications, can be: info, warn, error.")
+	flag.StringVar(&teamsURL, "teams_url", "", "Microsoft Teams hook URL.")
+	flag.StringVar(&teamsSecret, "teams_secret", "", "Secret holding the Microsoft Teams hook URL as namespace/name/key, overrides the Microsoft Teams URL.")
+	flag.StringVar(&teamsSeverity, "teams_severity", notifier.SeverityInfo, "Minimum severity of the Microsoft Teams notifications, can be: info, warn, error.")
+	flag.StringVar(&discordURL, "discord_url", "", "Discord hook URL.")
+	flag.StringVar(&discordSecret, "discord_secret", "", "Secret holding the Discord hook URL as namespace/name/key, overrides the Discord URL.")
+	flag.StringVar(&discordUser, "discord_user", "", "Discord user name, the name of the webhook is used when empty.")
+	flag.StringVar(&discordSeverity, "discord_severity", notifier.SeverityInfo, "Minimum severity of the Discord notifications, can be: info, warn, error.")
+	flag.StringVar(&genericURL, "generic_url", "", "Generic JSON webhook URL.")
+	flag.StringVar(&genericSecret, "generic_secret", "", "Secret holding the generic webhook URL as namespace/name/key, overrides the generic webhook URL.")
+	flag.StringVar(&genericTemplate, "generic_template", "", "Path to a Go template file rendering the generic webhook JSON body.")
+	flag.StringVar(&genericSeverity, "generic_severity", notifier.SeverityInfo, "Minimum severity of the generic webhook notifications, can be: info, warn, error.")
+	flag.IntVar(&notifyRetries, "notification-retries", 3, "Number of retries for a failed notification.")
+	flag.DurationVar(&notifyMinBackoff, "notification-min-backoff", 500*time.Millisecond, "Wait before the first notification retry, doubled on every retry.")
+	flag.DurationVar(&notifyMaxBackoff, "notification-max-backoff", 30*time.Second, "Maximum wait between notification retries.")
+	flag.DurationVar(&notifyTimeout, "notification-timeout", 5*time.Second, "Timeout of a notification attempt.")
+	flag.IntVar(&notifyDeadLetters, "notification-dead-letters", 100, "Number of dropped notifications kept in memory.")
+	flag.IntVar(&notifyWorkers, "notification-workers", 2, "Number of workers posting the queued notifications.")
+	flag.IntVar(&notifyQueueSize, "notification-queue-size", 100, "Maximum number of queued notifications.")
+	flag.StringVar(&notifyOverflow, "notification-overflow", notifier.OverflowDropNewest, "What to do when the notification queue is full, can be: drop-newest, drop-oldest, block.")
+	flag.DurationVar(&notifyBlockTimeout, "notification-block-timeout", time.Second, "Maximum wait for room in the notification queue with the block overflow policy.")
+	flag.DurationVar(&notifyDedupWindow, "notification-dedup-window", 5*time.Minute, "Window in which identical notifications of a canary are suppressed, 0 disables de-duplication.")
+	flag.IntVar(&notifyCanaryLimit, "notification-canary-rate-limit", 10, "Maximum notifications per minute for a canary, 0 disables the limit.")
+	flag.IntVar(&notifyGlobalLimit, "notification-global-rate-limit", 60, "Maximum notifications per minute for all canaries, 0 disables the limit.")
+	flag.StringVar(&notifyTemplates, "notification-templates", "", "Path to the message templates, a directory with one <event>.tmpl file per event (started, succeeded, failed, deleted, default) or a YAML file mapping events to templates.")
+	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false, "Enable leader election.")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "kube-system", "Namespace used to create the leader election lease.")
+	flag.DurationVar(&leaseDuration, "leader-election-lease-duration", 15*time.Second, "Duration that non-leader candidates will wait before forcing to acquire leadership.")
+	flag.DurationVar(&renewDeadline, "leader-election-renew-deadline", 10*time.Second, "Duration that the acting leader will retry refreshing leadership before giving up.")
+	flag.DurationVar(&retryPeriod, "leader-election-retry-period", 2*time.Second, "Duration the leader election clients should wait between tries of actions.")
+	flag.StringVar(&port, "port", "8081", "Port of the metrics and health HTTP server.")
+	flag.StringVar(&webhookPort, "webhook-port", "8443", "Port of the admission webhook server.")
+	flag.StringVar(&tlsCertFile, "tls-cert-file", "", "Path to the admission webhook TLS certificate, the webhook server is disabled when empty.")
+	flag.StringVar(&tlsKeyFile, "tls-key-file", "", "Path to the admission webhook TLS private key.")
+	flag.StringVar(&configFile, "config", "", "Path to the YAML or JSON configuration file, flags set on the command line override it.")
+	flag.DurationVar(&configReloadInterval, "config-reload-interval", 10*time.Second, "Interval between checks of the configuration file for changes to the log level and notifiers.")
+	flag.StringVar(&prometheusAddress, "prometheus-address", "", "Address of the Prometheus server evaluating the canary metrics, e.g. http://prometheus.monitoring:9090.")
+	flag.DurationVar(&prometheusTimeout, "prometheus-timeout", 5*time.Second, "Timeout of a Prometheus query.")
 }
 
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
 
+	flagsSet := commandLineFlags()
+	var controllerConfig *config.Configuration
+	var loadedConfig []byte
+	if configFile != "" {
+		var err error
+		controllerConfig, loadedConfig, err = config.Load(configFile)
+		if err != nil {
+			log.Fatalf("Error loading configuration: %v", err)
+		}
+		applyConfig(controllerConfig, flagsSet)
+	}
+
 	// ============== 日志初始化 BEGIN =============
-	logger, err := logger.NewLoggerWithEncoding(loglevel, zapEncoding)
+	logger, logLevel, err := logger.NewLoggerWithLevel(loglevel, zapEncoding)
 	if err != nil {
 		log.Fatalf("Error Create Logger: %v", err)
 	}
@@ -93,6 +212,20 @@ func main() {
 	cfg.QPS = float32(kubeconfigQPS)
 	cfg.Burst = kubeconfigBurst
 
+	// leader election context
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the leader election client is not wrapped so that the lease can
+	// still be released after the context has been cancelled
+	leaderElectionClient, err := kubernetes.NewForConfig(rest.CopyConfig(cfg))
+	if err != nil {
+		logger.Fatalf("Error Building leader election clientset: %v", err)
+	}
+
+	// prevents new requests when leadership is lost
+	cfg.Wrap(transport.ContextCanceller(ctx, fmt.Errorf("the leader is shutting down")))
+
 	kubeClient, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		logger.Fatalf("Error Building kubernetes clientset: %v", err)
@@ -106,48 +239,100 @@ func main() {
 		logger.Fatalf("Error Building example clientset", err)
 	}
 
-	verifyCRDs(exampleClient, logger)
-
 	// ============== 创建exampleClient END =============
 
 	// 验证Kubernetes版本
 	verifyKubernetesVersion(kubeClient, logger)
 
-	//informerFactory工厂类， 这里注入我们通过代码生成的client
-	//clent主要用于和API Server 进行通信，实现ListAndWatch
-	infos := startInformers(exampleClient, logger, stopCh)
-
-	labels := strings.Split(selectorLabels, ",")
+	var labels []string
+	for _, label := range strings.Split(selectorLabels, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
 	if len(labels) < 1 {
 		logger.Fatalf("At least one selector label is required")
 	}
 
-	if namespace != "" {
-		logger.Infof("Watching namespace %s", namespace)
+	watch := controller.WatchOptions{}
+	for _, ns := range strings.Split(namespace, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			watch.Namespaces = append(watch.Namespaces, ns)
+		}
+	}
+	if namespaceSelector != "" {
+		watch.Selector, err = k8slabels.Parse(namespaceSelector)
+		if err != nil {
+			logger.Fatalf("Invalid namespace selector %s: %v", namespaceSelector, err)
+		}
 	}
 
-	// setup Slack
-	notifierClient := initNotifier(logger)
+	verifyCRDs(kubeClient, exampleClient, watch, logger)
 
-	// 启动一个Web Server
-	go server.ListenAndServe("8081", 3*time.Second, logger, stopCh)
-
-	c := controller.NewController(
-		kubeClient,
-		exampleClient,
-		infos,
-		controlLoopInterval,
-		notifierClient,
-		fromEnv("EVENT_WEBHOOK_URL", eventWebhook),
-		logger,
-	)
+	var prometheusClient *prometheus.Client
+	if prometheusAddress != "" {
+		prometheusClient, err = prometheus.NewClient(prometheusAddress, prometheusTimeout)
+		if err != nil {
+			logger.Fatalf("Error creating Prometheus client: %v", err)
+		}
+	}
 
-	// leader election context
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// the work queue of the controller reports to the workqueue metrics
+	metrics.Register()
+	recorder := metrics.NewRecorder("example", true)
 
-	// prevents new requests when leadership is lost
-	cfg.Wrap(transport.ContextCanceller(ctx, fmt.Errorf("the leader is shutting down")))
+	// setup notification providers
+	// the notifications still queued on shutdown get a single attempt
+	notificationClient := newNotifierClient(ctx)
+	builder := newNotifierBuilder(kubeClient, notificationClient, recorder, logger, stopCh)
+	flagged := flagNotifiers(logger)
+	notifierClient := initNotifier(builder, configNotifiers(flagged, controllerConfig), logger)
+
+	onNotifierError := func(workload, namespace string, err error) {
+		logger.With("canary", fmt.Sprintf("%s.%s", workload, namespace)).Errorf("Notifier %v", err)
+	}
+
+	// send the notifications of a canary to the provider of its spec if any
+	router := notifier.NewRouter(notifierClient)
+
+	// reload the log level and the notifiers when the configuration changes
+	if configFile != "" {
+		go config.Watch(configFile, loadedConfig, configReloadInterval, func(next *config.Configuration) error {
+			applied, err := reloadConfig(next, controllerConfig, flagsSet, logLevel, flagged, builder, router, logger)
+			controllerConfig = applied
+			return err
+		}, logger, stopCh)
+	}
+
+	// post notifications in the background, the queue is drained on shutdown
+	dispatcher, err := notifier.NewAsync(router, notifyWorkers, notifyQueueSize, notifyOverflow, notifyBlockTimeout, onNotifierError, recorder)
+	if err != nil {
+		logger.Fatalf("Error creating notification dispatcher: %v", err)
+	}
+	drained := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx.Done())
+		close(drained)
+	}()
+
+	var templates *notifier.Templates
+	if notifyTemplates != "" {
+		templates, err = notifier.LoadTemplates(notifyTemplates)
+		if err != nil {
+			logger.Fatalf("Error loading notification templates: %v", err)
+		}
+	}
+
+	// suppress duplicates before they reach the queue
+	deduplicator := notifier.NewDeduplicator(dispatcher, notifyDedupWindow, notifyCanaryLimit, notifyGlobalLimit, onNotifierError, recorder)
+
+	// 启动一个Web Server
+	go server.ListenAndServe(port, 3*time.Second, notificationClient.DeadLetters(), logger, stopCh)
+
+	// 启动准入 Webhook Server
+	if tlsCertFile != "" && tlsKeyFile != "" {
+		go webhook.ListenAndServeTLS(webhookPort, tlsCertFile, tlsKeyFile, 3*time.Second, logger, stopCh)
+	}
 
 	// cancel leader election context on shutdown signals
 	go func() {
@@ -155,28 +340,260 @@ func main() {
 		cancel()
 	}()
 
-	// wrap controller run
-	runController := func() {
-		if err := c.Run(threadiness, stopCh); err != nil {
+	// wrap controller run, informers and workers are stopped with the context
+	runController := func(ctx context.Context) {
+		//informerFactory工厂类， 这里注入我们通过代码生成的client
+		//clent主要用于和API Server 进行通信，实现ListAndWatch
+		newInformers := newInformerFactory(kubeClient, exampleClient)
+
+		c := controller.NewController(
+			kubeClient,
+			exampleClient,
+			newInformers,
+			watch,
+			controlLoopInterval,
+			deduplicator,
+			notificationClient,
+			templates,
+			fromEnv("EVENT_WEBHOOK_URL", eventWebhook),
+			labels,
+			prometheusClient,
+			recorder,
+			logger,
+		)
+
+		if err := c.Run(threadiness, ctx.Done()); err != nil {
 			logger.Fatalf("Error running controller: %v", err)
 		}
 	}
 
-	runController()
+	if enableLeaderElection {
+		startLeaderElection(ctx, runController, leaderElectionClient, logger, cancel)
+	} else {
+		runController(ctx)
+	}
+
+	select {
+	case <-drained:
+	case <-time.After(30 * time.Second):
+		logger.Warn("Timed out waiting for the notification queue to drain")
+	}
+}
+
+func startLeaderElection(ctx context.Context, run func(ctx context.Context), kubeClient kubernetes.Interface, logger *zap.SugaredLogger, cancel context.CancelFunc) {
+	leaseName := "example-leader-election"
+	id, err := os.Hostname()
+	if err != nil {
+		logger.Fatalf("Error parsing hostname: %v", err)
+	}
+	id = id + "_" + string(uuid.NewUUID())
+
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		leaderElectionNamespace,
+		leaseName,
+		kubeClient.CoreV1(),
+		kubeClient.CoordinationV1(),
+		resourcelock.ResourceLockConfig{
+			Identity: id,
+		},
+	)
+	if err != nil {
+		logger.Fatalf("Error running controller: %v", err)
+	}
+
+	logger.Infof("Starting leader election id: %s lease: %s.%s", id, leaseName, leaderElectionNamespace)
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logger.Info("Acting as elected leader")
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				// the workers and informers have been stopped with the leader context,
+				// cancel the rest of the requests and let the process exit
+				logger.Info("Leadership lost")
+				cancel()
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					logger.Infof("Another instance has been elected as leader: %v", identity)
+				}
+			},
+		},
+	})
 }
 
-func initNotifier(logger *zap.SugaredLogger) (client notifier.Interface) {
-	provider := "slack"
-	notifierURL := fromEnv("SLACK_URL", slackURL)
-	notifierFactory := notifier.NewFactory(notifierURL, slackUser, slackChannel)
+// newNotifierClient creates the client posting the notifications of every provider,
+// the backoff between the attempts stops with ctx
+func newNotifierClient(ctx context.Context) *notifier.Client {
+	retry := notifier.RetryPolicy{
+		Retries:    notifyRetries,
+		MinBackoff: notifyMinBackoff,
+		MaxBackoff: notifyMaxBackoff,
+		Timeout:    notifyTimeout,
+	}
+	return notifier.NewClient(ctx, retry, notifier.NewDeadLetterQueue(notifyDeadLetters))
+}
 
-	client, err := notifierFactory.Notifier(provider)
+func initNotifier(builder *notifierBuilder, settings []notifierSettings, logger *zap.SugaredLogger) notifier.Interface {
+	client, err := builder.build(settings)
 	if err != nil {
-		logger.Errorf("Notifier %v", err)
-	} else if len(notifierURL) > 30 {
-		logger.Infof("Notifications enabled for %s", notifierURL[0:30])
+		logger.Fatalf("Error creating notifiers: %v", err)
+	}
+	return client
+}
+
+// notifierSettings configures a global notification provider
+type notifierSettings struct {
+	provider string
+	url      string
+	secret   string
+	username string
+	channel  string
+	severity string
+	template string
+}
+
+// flagNotifiers returns the providers configured with flags or environment variables
+func flagNotifiers(logger *zap.SugaredLogger) []notifierSettings {
+	var bodyTemplate string
+	if genericTemplate != "" {
+		data, err := ioutil.ReadFile(genericTemplate)
+		if err != nil {
+			logger.Fatalf("Error reading generic webhook template: %v", err)
+		}
+		bodyTemplate = string(data)
+	}
+
+	providers := []notifierSettings{
+		{"slack", fromEnv("SLACK_URL", slackURL), slackSecret, slackUser, slackChannel, slackSeverity, ""},
+		{"rocket", fromEnv("ROCKET_URL", rocketURL), rocketSecret, rocketUser, rocketChannel, rocketSeverity, ""},
+		{"msteams", fromEnv("TEAMS_URL", teamsURL), teamsSecret, "", "", teamsSeverity, ""},
+		{"discord", fromEnv("DISCORD_URL", discordURL), discordSecret, discordUser, "", discordSeverity, ""},
+		{"generic", fromEnv("GENERIC_URL", genericURL), genericSecret, "", "", genericSeverity, bodyTemplate},
+	}
+
+	var settings []notifierSettings
+	for _, p := range providers {
+		if p.url != "" || p.secret != "" {
+			settings = append(settings, p)
+		}
+	}
+	return settings
+}
+
+// secretSyncTimeout bounds the wait for the cache of a notifier Secret
+const secretSyncTimeout = 30 * time.Second
+
+// notifierBuilder creates the global notifier, the Secret caches are
+// shared by the successive notifiers built on configuration reloads
+type notifierBuilder struct {
+	kubeClient kubernetes.Interface
+	client     *notifier.Client
+	recorder   metrics.Recorder
+	logger     *zap.SugaredLogger
+	stopCh     <-chan struct{}
+	listers    map[notifier.SecretReference]corelisters.SecretLister
+}
+
+func newNotifierBuilder(kubeClient kubernetes.Interface, client *notifier.Client, recorder metrics.Recorder, logger *zap.SugaredLogger, stopCh <-chan struct{}) *notifierBuilder {
+	return &notifierBuilder{
+		kubeClient: kubeClient,
+		client:     client,
+		recorder:   recorder,
+		logger:     logger,
+		stopCh:     stopCh,
+		listers:    map[notifier.SecretReference]corelisters.SecretLister{},
+	}
+}
+
+// build fans out the notifications to the providers, it must not be called concurrently
+func (b *notifierBuilder) build(settings []notifierSettings) (notifier.Interface, error) {
+	var routes []notifier.Route
+	for _, p := range settings {
+		if !notifier.IsValidSeverity(p.severity) {
+			return nil, fmt.Errorf("invalid %s notifier severity %s", p.provider, p.severity)
+		}
+
+		notifierFactory := notifier.NewFactory(p.url, p.username, p.channel, b.client)
+		notifierFactory.Template = p.template
+
+		var client notifier.Interface
+		if p.secret != "" {
+			ref, err := notifier.ParseSecretReference(p.secret)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s notifier secret: %w", p.provider, err)
+			}
+			if p.url != "" {
+				b.logger.Warnf("Both a URL and a secret are set for %s, the secret %s is used", p.provider, ref)
+			}
+			lister, ok := b.listers[ref]
+			if !ok {
+				lister, err = watchSecret(b.kubeClient, ref, secretSyncTimeout, b.stopCh)
+				if err != nil {
+					return nil, fmt.Errorf("%s notifier: %w", p.provider, err)
+				}
+				b.listers[ref] = lister
+			}
+			client = notifier.NewSecretNotifier(p.provider, *notifierFactory, ref, notifier.ListerSecretGetter(lister))
+		} else {
+			var err error
+			client, err = notifierFactory.Notifier(p.provider)
+			if err != nil {
+				b.logger.Errorf("Notifier %v", err)
+				continue
+			}
+		}
+
+		routes = append(routes, notifier.Route{
+			Provider:    p.provider,
+			Notifier:    notifier.NewInstrumented(p.provider, client, b.recorder),
+			MinSeverity: p.severity,
+		})
+		b.logger.Infof("Notifications enabled for %s with minimum severity %s", p.provider, p.severity)
+	}
+
+	return notifier.NewComposite(routes...), nil
+}
+
+// watchSecret caches a single Secret, the informer is limited to its name so that
+// listing the other Secrets of the namespace is not required, the informer is
+// stopped and an error returned when the cache does not sync within the timeout
+func watchSecret(kubeClient kubernetes.Interface, ref notifier.SecretReference, timeout time.Duration, stopCh <-chan struct{}) (corelisters.SecretLister, error) {
+	factory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 30*time.Second,
+		kubeinformers.WithNamespace(ref.Namespace),
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", ref.Name).String()
+		}))
+	secretInformer := factory.Core().V1().Secrets()
+
+	stop := make(chan struct{})
+	failed := make(chan struct{})
+	go func() {
+		select {
+		case <-stopCh:
+		case <-failed:
+		}
+		close(stop)
+	}()
+	go secretInformer.Informer().Run(stop)
+
+	// the cache never syncs when listing the Secret is denied, the wait is bounded so that a reload cannot hang
+	timedOut := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(timedOut) })
+	defer timer.Stop()
+	if ok := cache.WaitForNamedCacheSync("example", timedOut, secretInformer.Informer().HasSynced); !ok {
+		close(failed)
+		return nil, fmt.Errorf("secret %s/%s cache did not sync within %s", ref.Namespace, ref.Name, timeout)
 	}
-	return
+	return secretInformer.Lister(), nil
 }
 
 func fromEnv(envVar, defaultVal string) string {
@@ -186,25 +603,41 @@ func fromEnv(envVar, defaultVal string) string {
 	return defaultVal
 }
 
-func startInformers(exampleClient clientset.Interface, logger *zap.SugaredLogger, stopch <-chan struct{}) controller.Informers {
-	exampleInformersFactory := informers.NewSharedInformerFactoryWithOptions(exampleClient, 30*time.Second, informers.WithNamespace(namespace))
-	logger.Info("Waiting for canary informer cache to sync")
+// newInformerFactory creates the informers of a namespace, they are started by the controller
+func newInformerFactory(kubeClient kubernetes.Interface, exampleClient clientset.Interface) controller.InformerFactory {
+	return func(namespace string) controller.Informers {
+		exampleInformersFactory := informers.NewSharedInformerFactoryWithOptions(exampleClient, 30*time.Second, informers.WithNamespace(namespace))
+		kubeInformersFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 30*time.Second, kubeinformers.WithNamespace(namespace))
 
-	canaryInformer := exampleInformersFactory.Example().V1beta1().Canaries()
-	go canaryInformer.Informer().Run(stopch)
-	if ok := cache.WaitForNamedCacheSync("example", stopch, canaryInformer.Informer().HasSynced); !ok {
-		logger.Fatalf("failed to wait for cache to sync")
+		return controller.Informers{
+			CanaryInformer:     exampleInformersFactory.Example().V1beta1().Canaries(),
+			DeploymentInformer: kubeInformersFactory.Apps().V1().Deployments(),
+			SecretInformer:     kubeInformersFactory.Core().V1().Secrets(),
+		}
 	}
+}
 
-	return controller.Informers{
-		CanaryInformer: canaryInformer,
+// verifyCRDs checks that the Canary CRD is served in the watched namespaces, the
+// canaries are listed in each namespace so that a namespaced role is enough
+func verifyCRDs(kubeClient kubernetes.Interface, exampleClient clientset.Interface, watch controller.WatchOptions, logger *zap.SugaredLogger) {
+	namespaces := watch.Namespaces
+	if watch.Selector != nil {
+		selected, err := kubeClient.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{LabelSelector: watch.Selector.String()})
+		if err != nil {
+			logger.Fatalf("Error listing the namespaces matching %s: %v", watch.Selector, err)
+		}
+		for _, ns := range selected.Items {
+			namespaces = append(namespaces, ns.Name)
+		}
+	} else if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
 	}
-}
 
-func verifyCRDs(exampleClient clientset.Interface, logger *zap.SugaredLogger) {
-	_, err := exampleClient.ExampleV1beta1().Canaries(namespace).List(context.TODO(), metav1.ListOptions{Limit: 1})
-	if err != nil {
-		logger.Fatalf("Canary CRD is not registered %v", err)
+	for _, ns := range namespaces {
+		_, err := exampleClient.ExampleV1beta1().Canaries(ns).List(context.TODO(), metav1.ListOptions{Limit: 1})
+		if err != nil {
+			logger.Fatalf("Canary CRD is not registered %v", err)
+		}
 	}
 }
 
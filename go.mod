@@ -18,4 +18,5 @@ require (
 	k8s.io/client-go v0.20.4
 	k8s.io/code-generator v0.20.4
 	k8s.io/klog/v2 v2.4.0
-)
\ No newline at end of file
+	sigs.k8s.io/yaml v1.2.0
+)
@@ -1,11 +1,12 @@
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
 )
 
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // Canary is the configuration for a canary release
@@ -14,6 +15,8 @@ type Canary struct {
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
 	Spec CanarySpec `json:"spec"`
+	// +optional
+	Status CanaryStatus `json:"status,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -31,4 +34,267 @@ type CanarySpec struct {
 	Image    string `json:"image,omitempty"`
 	Cron     string `json:"cron"`
 	Replicas int32  `json:"replicas"`
+
+	// Suspend stops the reconciliation of the canary, the rollout resumes
+	// where it stopped once it is cleared
+	// +optional
+	Suspend *bool `json:"suspend,omitempty"`
+
+	// Notifications overrides the global notifier for this canary
+	// +optional
+	Notifications *CanaryNotifications `json:"notifications,omitempty"`
+
+	// TargetRef is the Deployment progressively moved to the image, the
+	// canary owns a Deployment of its own when it is not set
+	// +optional
+	TargetRef *CanaryTargetReference `json:"targetRef,omitempty"`
+	// Steps are the percentages of the replicas running the image, the
+	// target is promoted to the image after the last step
+	// +optional
+	Steps []int32 `json:"steps,omitempty"`
+	// Interval is the minimum time spent on a step
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// Analysis configures the checks that roll back a failing rollout
+	// +optional
+	Analysis *CanaryAnalysis `json:"analysis,omitempty"`
+	// Metrics are the PromQL queries that must be within their threshold
+	// range before each step
+	// +optional
+	Metrics []CanaryMetric `json:"metrics,omitempty"`
+	// Webhooks are the external gates called during the rollout
+	// +optional
+	Webhooks []CanaryWebhook `json:"webhooks,omitempty"`
+}
+
+// CanaryWebhookType is the stage of the rollout a webhook is called at, the rollout
+// only advances while the webhooks of its stage return 2xx, any other answer halts
+// or rolls it back
+type CanaryWebhookType string
+
+const (
+	// CanaryWebhookPreRollout is called before the canary Deployment is created,
+	// the rollout starts once it returns 2xx and a failure counts as a failed check
+	CanaryWebhookPreRollout CanaryWebhookType = "pre-rollout"
+	// CanaryWebhookRollout is called with every check of a step, a failure
+	// counts as a failed check
+	CanaryWebhookRollout CanaryWebhookType = "rollout"
+	// CanaryWebhookConfirmPromotion is called once per analysis interval after the
+	// last step, the target is promoted once it returns 2xx
+	CanaryWebhookConfirmPromotion CanaryWebhookType = "confirm-promotion"
+	// CanaryWebhookPostRollout is called once the rollout succeeded or was
+	// rolled back, its result is ignored
+	CanaryWebhookPostRollout CanaryWebhookType = "post-rollout"
+	// CanaryWebhookRollback is called once per analysis interval until the promotion,
+	// the rollout is rolled back at once when it does not return 2xx
+	CanaryWebhookRollback CanaryWebhookType = "rollback"
+)
+
+// CanaryWebhook is an HTTP endpoint receiving a CanaryWebhookPayload
+type CanaryWebhook struct {
+	Name string            `json:"name"`
+	Type CanaryWebhookType `json:"type"`
+	URL  string            `json:"url"`
+	// Timeout of a call
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Metadata is passed to the webhook in the payload
+	// +optional
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// CanaryMetric is a PromQL query and the range of its accepted values
+type CanaryMetric struct {
+	Name string `json:"name"`
+	// Query is a Go template receiving the Name and Namespace of the canary,
+	// the Target and the Deployment running the canary image
+	Query string `json:"query"`
+	// ThresholdRange is the range of the accepted values
+	ThresholdRange CanaryThresholdRange `json:"thresholdRange"`
+}
+
+// CanaryThresholdRange bounds the value of a metric, the bounds are inclusive
+type CanaryThresholdRange struct {
+	// +optional
+	Min *float64 `json:"min,omitempty"`
+	// +optional
+	Max *float64 `json:"max,omitempty"`
+}
+
+// CanaryAnalysis configures the checks run while the canary pods roll out
+type CanaryAnalysis struct {
+	// Interval between two checks
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// Threshold is the number of failed checks in a row that rolls back the rollout
+	// +optional
+	Threshold int32 `json:"threshold,omitempty"`
+}
+
+// CanaryTargetReference selects a Deployment in the canary namespace
+type CanaryTargetReference struct {
+	Name string `json:"name"`
+}
+
+// CanaryNotifications is the notification provider of a canary
+type CanaryNotifications struct {
+	// Provider can be slack, rocket, msteams, discord or generic
+	Provider string `json:"provider"`
+	// +optional
+	Channel string `json:"channel,omitempty"`
+	// +optional
+	Username string `json:"username,omitempty"`
+	// SecretRef selects the key of a Secret in the canary namespace holding the hook URL
+	SecretRef corev1.SecretKeySelector `json:"secretRef"`
+	// MinSeverity can be info, warn or error
+	// +optional
+	MinSeverity string `json:"minSeverity,omitempty"`
+}
+
+// CanaryPhase is a label for the condition of a canary at the current time
+type CanaryPhase string
+
+const (
+	// CanaryPhaseInitializing means the canary has been seen by the controller
+	// but has not been reconciled yet
+	CanaryPhaseInitializing CanaryPhase = "Initializing"
+	// CanaryPhaseProgressing means the canary is being reconciled
+	CanaryPhaseProgressing CanaryPhase = "Progressing"
+	// CanaryPhaseSucceeded means the last reconcile finished successfully
+	CanaryPhaseSucceeded CanaryPhase = "Succeeded"
+	// CanaryPhaseFailed means the last reconcile failed
+	CanaryPhaseFailed CanaryPhase = "Failed"
+)
+
+const (
+	// CanaryConditionReady is the condition type reporting whether the
+	// last reconcile of the canary succeeded
+	CanaryConditionReady = "Ready"
+	// CanaryConditionScheduled is the condition type reporting whether the
+	// cron expression could be scheduled
+	CanaryConditionScheduled = "Scheduled"
+	// CanaryConditionSelected is the condition type reporting whether the
+	// canary carries one of the selector labels used to select its pods
+	CanaryConditionSelected = "Selected"
+	// CanaryConditionPaused is the condition type reporting whether the canary
+	// is suspended or its rollout is held by the gate annotation
+	CanaryConditionPaused = "Paused"
+)
+
+// CanaryGateAnnotation lets an operator hold, approve or abort a rollout
+const CanaryGateAnnotation = "example.app/gate"
+
+const (
+	// CanaryGateHold keeps the rollout at its current step until the annotation is removed
+	CanaryGateHold = "hold"
+	// CanaryGateApprove promotes the target at once, the annotation is removed
+	CanaryGateApprove = "approve"
+	// CanaryGateAbort rolls the rollout back, the annotation is removed
+	CanaryGateAbort = "abort"
+)
+
+// Defaults of the optional rollout settings, the mutating webhook stores them in the
+// spec and the controller falls back to them for the canaries admitted without it
+const (
+	// DefaultStepWeight promotes the target at once when the canary has no steps
+	DefaultStepWeight int32 = 100
+	// DefaultStepInterval is the time spent at each step
+	DefaultStepInterval = time.Minute
+	// DefaultAnalysisInterval is the time between two checks of the canary
+	DefaultAnalysisInterval = 10 * time.Second
+	// DefaultFailureThreshold is the number of failed checks in a row that rolls the canary back
+	DefaultFailureThreshold int32 = 3
+	// DefaultWebhookTimeout is the time a webhook has to answer
+	DefaultWebhookTimeout = 10 * time.Second
+)
+
+// CanaryStatus is used for state persistence (read-only)
+type CanaryStatus struct {
+	// +optional
+	Phase CanaryPhase `json:"phase,omitempty"`
+	// ObservedGeneration is the last spec generation reconciled by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastTransitionTime is the last time the phase changed
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// LastScheduleTime is the last time the cron schedule fired
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// NextScheduleTime is the next time the cron schedule fires
+	// +optional
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"`
+	// StableImage is the last known good image, rollbacks revert to it
+	// +optional
+	StableImage string `json:"stableImage,omitempty"`
+	// CanaryImage is the image being rolled out
+	// +optional
+	CanaryImage string `json:"canaryImage,omitempty"`
+	// CurrentStep is the index of the rollout step in progress
+	// +optional
+	CurrentStep int32 `json:"currentStep,omitempty"`
+	// CurrentWeight is the percentage of the replicas running the canary image
+	// +optional
+	CurrentWeight int32 `json:"currentWeight,omitempty"`
+	// StepStartTime is the time the current step started
+	// +optional
+	StepStartTime *metav1.Time `json:"stepStartTime,omitempty"`
+	// FailedChecks is the number of failed checks in a row
+	// +optional
+	FailedChecks int32 `json:"failedChecks,omitempty"`
+	// LastCheckTime is the time of the last check
+	// +optional
+	LastCheckTime *metav1.Time `json:"lastCheckTime,omitempty"`
+	// MetricResults are the results of the last metric queries
+	// +optional
+	MetricResults []CanaryMetricResult `json:"metricResults,omitempty"`
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// CanaryMetricResult is the result of a metric query
+type CanaryMetricResult struct {
+	Name string `json:"name"`
+	// Value returned by the query
+	// +optional
+	Value string `json:"value,omitempty"`
+	// Passed is true when the value is within the threshold range
+	Passed bool `json:"passed"`
+	// Message explains why the check failed
+	// +optional
+	Message string `json:"message,omitempty"`
+	// LastCheckTime is the time the query ran
+	LastCheckTime metav1.Time `json:"lastCheckTime"`
+}
+
+// CanaryEventPayloadVersion is the apiVersion of the event webhook payload
+const CanaryEventPayloadVersion = "example.app/v1beta1"
+
+// CanaryWebhookPayload is posted to the webhooks of the canary spec
+// +k8s:deepcopy-gen=false
+type CanaryWebhookPayload struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Name       string            `json:"name"`
+	Namespace  string            `json:"namespace"`
+	Phase      CanaryPhase       `json:"phase"`
+	Type       CanaryWebhookType `json:"type"`
+	Image      string            `json:"image"`
+	Weight     int32             `json:"weight"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Timestamp  metav1.Time       `json:"timestamp"`
+}
+
+// CanaryEventPayload holds the fields sent to the event webhook
+// +k8s:deepcopy-gen=false
+type CanaryEventPayload struct {
+	APIVersion string      `json:"apiVersion"`
+	Kind       string      `json:"kind"`
+	Name       string      `json:"name"`
+	Namespace  string      `json:"namespace"`
+	Phase      CanaryPhase `json:"phase"`
+	EventType  string      `json:"eventType"`
+	Reason     string      `json:"reason"`
+	Message    string      `json:"message"`
+	Timestamp  metav1.Time `json:"timestamp"`
 }
@@ -40,6 +40,7 @@ type CanariesGetter interface {
 type CanaryInterface interface {
 	Create(ctx context.Context, canary *v1beta1.Canary, opts v1.CreateOptions) (*v1beta1.Canary, error)
 	Update(ctx context.Context, canary *v1beta1.Canary, opts v1.UpdateOptions) (*v1beta1.Canary, error)
+	UpdateStatus(ctx context.Context, canary *v1beta1.Canary, opts v1.UpdateOptions) (*v1beta1.Canary, error)
 	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
 	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
 	Get(ctx context.Context, name string, opts v1.GetOptions) (*v1beta1.Canary, error)
@@ -135,6 +136,22 @@ func (c *canaries) Update(ctx context.Context, canary *v1beta1.Canary, opts v1.U
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *canaries) UpdateStatus(ctx context.Context, canary *v1beta1.Canary, opts v1.UpdateOptions) (result *v1beta1.Canary, err error) {
+	result = &v1beta1.Canary{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("canaries").
+		Name(canary.Name).
+		SubResource("status").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(canary).
+		Do(ctx).
+		Into(result)
+	return
+}
+
 // Delete takes name of the canary and deletes it. Returns an error if one occurs.
 func (c *canaries) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
 	return c.client.Delete().
@@ -102,6 +102,18 @@ func (c *FakeCanaries) Update(ctx context.Context, canary *v1beta1.Canary, opts
 	return obj.(*v1beta1.Canary), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeCanaries) UpdateStatus(ctx context.Context, canary *v1beta1.Canary, opts v1.UpdateOptions) (*v1beta1.Canary, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(canariesResource, "status", c.ns, canary), &v1beta1.Canary{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1beta1.Canary), err
+}
+
 // Delete takes name of the canary and deletes it. Returns an error if one occurs.
 func (c *FakeCanaries) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
 	_, err := c.Fake.
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"net/url"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// Version of the configuration file
+const (
+	APIVersion = "example.app/v1alpha1"
+	Kind       = "ControllerConfiguration"
+)
+
+var logLevels = []string{"debug", "info", "warn", "error", "fatal", "panic"}
+
+var notifierProviders = []string{"slack", "rocket", "msteams", "discord", "generic"}
+
+// Configuration is the controller configuration file, it accepts YAML or JSON
+type Configuration struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// Namespaces watched for canaries, all namespaces when both namespaces
+	// and namespaceSelector are empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector adds the namespaces matching the label selector
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+	// SelectorLabels are the pod labels used to create pod selectors
+	SelectorLabels []string `json:"selectorLabels,omitempty"`
+	// Threadiness is the number of workers
+	Threadiness int `json:"threadiness,omitempty"`
+	// LogLevel can be changed without a restart
+	LogLevel string `json:"logLevel,omitempty"`
+
+	Server ServerConfiguration `json:"server,omitempty"`
+
+	Prometheus PrometheusConfiguration `json:"prometheus,omitempty"`
+
+	// Notifiers can be changed without a restart
+	Notifiers []NotifierConfiguration `json:"notifiers,omitempty"`
+}
+
+// ServerConfiguration holds the ports of the HTTP servers
+type ServerConfiguration struct {
+	Port        string `json:"port,omitempty"`
+	WebhookPort string `json:"webhookPort,omitempty"`
+}
+
+// PrometheusConfiguration is the Prometheus server evaluating the canary metrics
+type PrometheusConfiguration struct {
+	Address string `json:"address,omitempty"`
+}
+
+// NotifierConfiguration is a global notification provider
+type NotifierConfiguration struct {
+	Provider string `json:"provider"`
+	// URL is the hook URL, prefer Secret to keep it out of the file
+	URL string `json:"url,omitempty"`
+	// Secret holding the hook URL as namespace/name/key
+	Secret   string `json:"secret,omitempty"`
+	Username string `json:"username,omitempty"`
+	Channel  string `json:"channel,omitempty"`
+	// MinSeverity can be info, warn or error
+	MinSeverity string `json:"minSeverity,omitempty"`
+	// Template is the body template of the generic provider
+	Template string `json:"template,omitempty"`
+}
+
+// Load reads and validates a configuration file, the content read is returned
+// as the baseline of Watch
+func Load(path string) (*Configuration, []byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading configuration failed: %w", err)
+	}
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, data, nil
+}
+
+// Parse decodes and validates a configuration, unknown fields are rejected
+func Parse(data []byte) (*Configuration, error) {
+	cfg := &Configuration{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("decoding configuration failed: %w", err)
+	}
+	if errs := Validate(cfg); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %w", errs.ToAggregate())
+	}
+	return cfg, nil
+}
+
+// Validate returns an error for each invalid field
+func Validate(cfg *Configuration) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if cfg.APIVersion != APIVersion {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("apiVersion"), cfg.APIVersion, []string{APIVersion}))
+	}
+	if cfg.Kind != Kind {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("kind"), cfg.Kind, []string{Kind}))
+	}
+
+	for i, ns := range cfg.Namespaces {
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("namespaces").Index(i), ns, msg))
+		}
+	}
+	if cfg.NamespaceSelector != "" {
+		if _, err := labels.Parse(cfg.NamespaceSelector); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("namespaceSelector"), cfg.NamespaceSelector, err.Error()))
+		}
+	}
+
+	for i, label := range cfg.SelectorLabels {
+		if strings.TrimSpace(label) == "" {
+			allErrs = append(allErrs, field.Required(field.NewPath("selectorLabels").Index(i), "label must not be empty"))
+		}
+	}
+	if cfg.Threadiness < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("threadiness"), cfg.Threadiness, "must be greater than or equal to 0"))
+	}
+	if cfg.LogLevel != "" && !contains(logLevels, cfg.LogLevel) {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("logLevel"), cfg.LogLevel, logLevels))
+	}
+
+	if cfg.Prometheus.Address != "" {
+		if u, err := url.Parse(cfg.Prometheus.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("prometheus", "address"), cfg.Prometheus.Address, "must be an http or https URL"))
+		}
+	}
+
+	for i, n := range cfg.Notifiers {
+		path := field.NewPath("notifiers").Index(i)
+		if !contains(notifierProviders, n.Provider) {
+			allErrs = append(allErrs, field.NotSupported(path.Child("provider"), n.Provider, notifierProviders))
+		}
+		if n.URL == "" && n.Secret == "" {
+			allErrs = append(allErrs, field.Required(path, "url or secret is required"))
+		}
+		if n.URL != "" && n.Secret != "" {
+			allErrs = append(allErrs, field.Forbidden(path.Child("url"), "url and secret are mutually exclusive"))
+		}
+		if n.Secret != "" {
+			if _, err := notifier.ParseSecretReference(n.Secret); err != nil {
+				allErrs = append(allErrs, field.Invalid(path.Child("secret"), n.Secret, err.Error()))
+			}
+		}
+		if n.MinSeverity != "" && !notifier.IsValidSeverity(n.MinSeverity) {
+			allErrs = append(allErrs, field.NotSupported(path.Child("minSeverity"), n.MinSeverity,
+				[]string{notifier.SeverityInfo, notifier.SeverityWarn, notifier.SeverityError}))
+		}
+	}
+
+	return allErrs
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const validConfig = `
+apiVersion: example.app/v1alpha1
+kind: ControllerConfiguration
+namespaces:
+  - test
+  - prod
+namespaceSelector: team=web
+selectorLabels:
+  - app
+threadiness: 4
+logLevel: debug
+server:
+  port: "8080"
+prometheus:
+  address: http://prometheus.monitoring:9090
+notifiers:
+  - provider: slack
+    secret: example/slack/url
+    channel: general
+    minSeverity: warn
+  - provider: generic
+    url: https://hooks.example.com/notify
+`
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(validConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Namespaces) != 2 || cfg.NamespaceSelector != "team=web" || cfg.Threadiness != 4 || cfg.LogLevel != "debug" {
+		t.Errorf("unexpected configuration %+v", cfg)
+	}
+	if cfg.Server.Port != "8080" || cfg.Prometheus.Address != "http://prometheus.monitoring:9090" {
+		t.Errorf("unexpected server or Prometheus configuration %+v %+v", cfg.Server, cfg.Prometheus)
+	}
+	if len(cfg.Notifiers) != 2 || cfg.Notifiers[0].Secret != "example/slack/url" || cfg.Notifiers[0].MinSeverity != "warn" {
+		t.Errorf("unexpected notifiers %+v", cfg.Notifiers)
+	}
+}
+
+func TestParse_JSON(t *testing.T) {
+	cfg, err := Parse([]byte(`{"apiVersion":"example.app/v1alpha1","kind":"ControllerConfiguration","logLevel":"info"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogLevel != "info" {
+		t.Errorf("expected log level info, got %s", cfg.LogLevel)
+	}
+}
+
+func TestParse_UnknownField(t *testing.T) {
+	_, err := Parse([]byte("apiVersion: example.app/v1alpha1\nkind: ControllerConfiguration\nlogLevl: debug\n"))
+	if err == nil || !strings.Contains(err.Error(), "decoding configuration failed") {
+		t.Errorf("expected unknown fields to be rejected, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Configuration {
+		return &Configuration{APIVersion: APIVersion, Kind: Kind}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(cfg *Configuration)
+		fields []string
+	}{
+		{"valid", func(cfg *Configuration) {}, nil},
+		{"wrong version", func(cfg *Configuration) { cfg.APIVersion = "v1" }, []string{"apiVersion"}},
+		{"wrong kind", func(cfg *Configuration) { cfg.Kind = "Config" }, []string{"kind"}},
+		{"invalid namespace", func(cfg *Configuration) { cfg.Namespaces = []string{"test", "Prod_1"} }, []string{"namespaces[1]"}},
+		{"invalid namespace selector", func(cfg *Configuration) { cfg.NamespaceSelector = "team in (web" }, []string{"namespaceSelector"}},
+		{"empty selector label", func(cfg *Configuration) { cfg.SelectorLabels = []string{"app", " "} }, []string{"selectorLabels[1]"}},
+		{"negative threadiness", func(cfg *Configuration) { cfg.Threadiness = -1 }, []string{"threadiness"}},
+		{"unknown log level", func(cfg *Configuration) { cfg.LogLevel = "verbose" }, []string{"logLevel"}},
+		{"invalid Prometheus address", func(cfg *Configuration) { cfg.Prometheus.Address = "prometheus:9090" }, []string{"prometheus.address"}},
+		{"unknown provider", func(cfg *Configuration) {
+			cfg.Notifiers = []NotifierConfiguration{{Provider: "email", URL: "https://hooks.example.com"}}
+		}, []string{"notifiers[0].provider"}},
+		{"no url nor secret", func(cfg *Configuration) {
+			cfg.Notifiers = []NotifierConfiguration{{Provider: "slack"}}
+		}, []string{"notifiers[0]"}},
+		{"url and secret", func(cfg *Configuration) {
+			cfg.Notifiers = []NotifierConfiguration{{Provider: "slack", URL: "https://hooks.example.com", Secret: "example/slack/url"}}
+		}, []string{"notifiers[0].url"}},
+		{"invalid secret", func(cfg *Configuration) {
+			cfg.Notifiers = []NotifierConfiguration{{Provider: "slack", Secret: "example/slack"}}
+		}, []string{"notifiers[0].secret"}},
+		{"unknown severity", func(cfg *Configuration) {
+			cfg.Notifiers = []NotifierConfiguration{{Provider: "slack", URL: "https://hooks.example.com", MinSeverity: "fatal"}}
+		}, []string{"notifiers[0].minSeverity"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(cfg)
+			errs := Validate(cfg)
+			if len(errs) != len(tt.fields) {
+				t.Fatalf("expected errors for %v, got %v", tt.fields, errs)
+			}
+			for i, err := range errs {
+				if err.Field != tt.fields[i] {
+					t.Errorf("expected an error for %s, got %v", tt.fields[i], err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(validConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Load(filepath.Join(dir, "missing.yaml")); err == nil || !strings.Contains(err.Error(), "reading configuration failed") {
+		t.Errorf("expected a read error, got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"bytes"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"time"
+)
+
+// Watch polls the configuration file and calls onChange with every version that
+// differs from loaded, the content the running configuration was loaded from,
+// polling also follows the symlink swaps of mounted ConfigMaps, a version that
+// onChange fails to apply is passed again on the next poll
+func Watch(path string, loaded []byte, interval time.Duration, onChange func(cfg *Configuration) error, logger *zap.SugaredLogger, stopCh <-chan struct{}) {
+	last := loaded
+	retry := false
+
+	wait.Until(func() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			logger.Errorf("Reading configuration %s failed: %v", path, err)
+			return
+		}
+		changed := !bytes.Equal(data, last)
+		if !changed && !retry {
+			return
+		}
+		last = data
+		retry = false
+
+		cfg, err := Parse(data)
+		if err != nil {
+			// keep running with the previous configuration
+			logger.Errorf("Ignoring configuration change: %v", err)
+			return
+		}
+		if changed {
+			logger.Infof("Configuration %s changed", path)
+		}
+		if err := onChange(cfg); err != nil {
+			logger.Errorf("Applying configuration %s failed, retrying in %s: %v", path, interval, err)
+			retry = true
+		}
+	}, interval, stopCh)
+}
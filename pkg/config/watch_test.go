@@ -0,0 +1,86 @@
+package config
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatch_RetriesFailedChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(validConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := make(chan struct{}, 10)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	failures := 2
+	go Watch(path, []byte(validConfig), 10*time.Millisecond, func(cfg *Configuration) error {
+		calls <- struct{}{}
+		if failures > 0 {
+			failures--
+			return errors.New("secret not found")
+		}
+		return nil
+	}, zap.NewNop().Sugar(), stopCh)
+
+	if err := ioutil.WriteFile(path, []byte(strings.Replace(validConfig, "logLevel: debug", "logLevel: info", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the unchanged file is applied again until onChange succeeds
+	for i := 0; i < 3; i++ {
+		select {
+		case <-calls:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d calls, got %d", 3, i)
+		}
+	}
+	select {
+	case <-calls:
+		t.Error("expected no call once the configuration is applied")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatch_ChangedAfterLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(validConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the change written between the load and the first poll is not missed
+	if err := ioutil.WriteFile(path, []byte(strings.Replace(validConfig, "logLevel: debug", "logLevel: info", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	levels := make(chan string, 10)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go Watch(path, loaded, 10*time.Millisecond, func(cfg *Configuration) error {
+		levels <- cfg.LogLevel
+		return nil
+	}, zap.NewNop().Sugar(), stopCh)
+
+	select {
+	case level := <-levels:
+		if level != "info" {
+			t.Errorf("expected the info log level, got %s", level)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the change to be applied")
+	}
+	select {
+	case <-levels:
+		t.Error("expected the change to be applied once")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
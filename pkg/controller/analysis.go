@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+)
+
+// defaultProgressDeadline is the progress deadline of Deployments that do not set one
+const defaultProgressDeadline = 600 * time.Second
+
+// failedWaitingReasons are the container waiting reasons of pods that will not become ready
+var failedWaitingReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// analyse runs the check once per analysis interval and counts the failed
+// checks in a row, true is returned once the threshold is reached, a check
+// waiting for its webhooks is left pending and repeated once they answered
+func (c *Controller) analyse(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, check func() error) bool {
+	if !isCheckDue(cd, status) {
+		return status.FailedChecks >= failureThreshold(cd)
+	}
+	now := metav1.Now()
+	status.LastCheckTime = &now
+
+	if err := check(); err != nil {
+		if isHooksPending(err) {
+			status.LastCheckTime = nil
+			return status.FailedChecks >= failureThreshold(cd)
+		}
+		status.FailedChecks++
+		c.recordEventWarningf(cd, ReasonCheckFailed, "Check %d/%d of %s.%s failed: %v",
+			status.FailedChecks, failureThreshold(cd), cd.Name, cd.Namespace, err)
+		return status.FailedChecks >= failureThreshold(cd)
+	}
+	status.FailedChecks = 0
+	return false
+}
+
+// checkCanary checks the pods of the Deployment running the canary image and,
+// once it is ready, the metrics and the rollout webhooks
+func (c *Controller) checkCanary(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, dep *appsv1.Deployment) error {
+	if err := c.checkDeployment(dep); err != nil {
+		return err
+	}
+	if !isDeploymentReady(dep) {
+		return nil
+	}
+	if err := c.checkMetrics(cd, status, dep); err != nil {
+		return err
+	}
+	return c.callHooks(cd, status, examplev1beta1.CanaryWebhookRollout)
+}
+
+// isCheckPending returns true when the last analyse waits for the webhooks
+func isCheckPending(status *examplev1beta1.CanaryStatus) bool {
+	return status.LastCheckTime == nil
+}
+
+// isCheckDue returns true when the last check is older than the analysis interval
+func isCheckDue(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus) bool {
+	return status.LastCheckTime == nil || time.Since(status.LastCheckTime.Time) >= analysisInterval(cd)
+}
+
+// checkDeployment returns an error when the Deployment exceeded its progress deadline,
+// one of its pods crash-loops or a pod has not been ready for longer than the deadline
+func (c *Controller) checkDeployment(dep *appsv1.Deployment) error {
+	for _, cond := range dep.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Status == corev1.ConditionFalse &&
+			cond.Reason == "ProgressDeadlineExceeded" {
+			return fmt.Errorf("deployment %s.%s exceeded its progress deadline", dep.Name, dep.Namespace)
+		}
+	}
+
+	pods, err := c.kubeClient.CoreV1().Pods(dep.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: metav1.FormatLabelSelector(dep.Spec.Selector),
+	})
+	if err != nil {
+		return fmt.Errorf("pods of deployment %s.%s list query error: %w", dep.Name, dep.Namespace, err)
+	}
+
+	// slow starting pods get the same grace as the rollout of the Deployment
+	deadline := progressDeadline(dep)
+
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Waiting != nil && failedWaitingReasons[cs.State.Waiting.Reason] {
+				return fmt.Errorf("container %s of pod %s is in %s", cs.Name, pod.Name, cs.State.Waiting.Reason)
+			}
+		}
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status != corev1.ConditionTrue &&
+				!cond.LastTransitionTime.IsZero() && time.Since(cond.LastTransitionTime.Time) > deadline {
+				return fmt.Errorf("pod %s has not been ready for %s", pod.Name,
+					time.Since(cond.LastTransitionTime.Time).Round(time.Second))
+			}
+		}
+	}
+	return nil
+}
+
+// isRolledBack reports whether the canary was rolled back for its current spec,
+// the rollout is retried once the spec changes
+func isRolledBack(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus) bool {
+	return hasStatusCondition(cd, status, examplev1beta1.CanaryConditionReady, metav1.ConditionFalse, ReasonRolledBack)
+}
+
+// markRolledBack fails the canary after a rollback, the event alerts the notifier
+func (c *Controller) markRolledBack(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, name, cause string) error {
+	c.recordEventErrorf(cd, ReasonRolledBack, "Rolled back %s.%s to %s, %s", name, cd.Namespace, status.StableImage, cause)
+	setStatusPhase(status, examplev1beta1.CanaryPhaseFailed)
+	setStatusCondition(cd, status, examplev1beta1.CanaryConditionReady, metav1.ConditionFalse, ReasonRolledBack,
+		fmt.Sprintf("Deployment %s.%s rolled back to %s, %s", name, cd.Namespace, status.StableImage, cause))
+	status.FailedChecks = 0
+	status.LastCheckTime = nil
+	c.callPostRolloutHooks(cd, status)
+	return c.syncStatus(cd, *status)
+}
+
+// failedChecksCause describes a rollback caused by the failure threshold
+func failedChecksCause(status *examplev1beta1.CanaryStatus) string {
+	return fmt.Sprintf("%d checks failed in a row", status.FailedChecks)
+}
+
+func progressDeadline(dep *appsv1.Deployment) time.Duration {
+	if dep.Spec.ProgressDeadlineSeconds == nil {
+		return defaultProgressDeadline
+	}
+	return time.Duration(*dep.Spec.ProgressDeadlineSeconds) * time.Second
+}
+
+func analysisInterval(cd *examplev1beta1.Canary) time.Duration {
+	if cd.Spec.Analysis == nil || cd.Spec.Analysis.Interval == nil {
+		return examplev1beta1.DefaultAnalysisInterval
+	}
+	return cd.Spec.Analysis.Interval.Duration
+}
+
+func failureThreshold(cd *examplev1beta1.Canary) int32 {
+	if cd.Spec.Analysis == nil || cd.Spec.Analysis.Threshold <= 0 {
+		return examplev1beta1.DefaultFailureThreshold
+	}
+	return cd.Spec.Analysis.Threshold
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strconv"
+	"text/template"
+)
+
+// MetricQueryData is passed to the query templates of the canary metrics
+type MetricQueryData struct {
+	Name       string
+	Namespace  string
+	Target     string
+	Deployment string
+}
+
+// checkMetrics runs the metric queries of the canary and records their results in the
+// status, an error is returned for the first metric that is out of its threshold range
+func (c *Controller) checkMetrics(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, dep *appsv1.Deployment) error {
+	if len(cd.Spec.Metrics) == 0 {
+		status.MetricResults = nil
+		return nil
+	}
+
+	data := MetricQueryData{
+		Name:       cd.Name,
+		Namespace:  cd.Namespace,
+		Target:     dep.Name,
+		Deployment: dep.Name,
+	}
+	if cd.Spec.TargetRef != nil {
+		data.Target = cd.Spec.TargetRef.Name
+	}
+
+	now := metav1.Now()
+	results := make([]examplev1beta1.CanaryMetricResult, 0, len(cd.Spec.Metrics))
+	var failed error
+	for _, metric := range cd.Spec.Metrics {
+		result := examplev1beta1.CanaryMetricResult{
+			Name:          metric.Name,
+			LastCheckTime: now,
+		}
+		value, err := c.queryMetric(metric, data)
+		if err == nil {
+			result.Value = strconv.FormatFloat(value, 'g', -1, 64)
+			err = checkThresholdRange(metric.ThresholdRange, value)
+		}
+		if err != nil {
+			result.Message = err.Error()
+			if failed == nil {
+				failed = fmt.Errorf("metric %s: %w", metric.Name, err)
+			}
+		} else {
+			result.Passed = true
+		}
+		results = append(results, result)
+	}
+	status.MetricResults = results
+	return failed
+}
+
+func (c *Controller) queryMetric(metric examplev1beta1.CanaryMetric, data MetricQueryData) (float64, error) {
+	if c.prometheus == nil {
+		return 0, fmt.Errorf("no Prometheus address is configured")
+	}
+
+	tmpl, err := template.New(metric.Name).Option("missingkey=error").Parse(metric.Query)
+	if err != nil {
+		return 0, fmt.Errorf("parsing query failed: %w", err)
+	}
+	var query bytes.Buffer
+	if err := tmpl.Execute(&query, data); err != nil {
+		return 0, fmt.Errorf("rendering query failed: %w", err)
+	}
+	return c.prometheus.Query(query.String())
+}
+
+func checkThresholdRange(r examplev1beta1.CanaryThresholdRange, value float64) error {
+	if r.Min != nil && value < *r.Min {
+		return fmt.Errorf("value %g is below the minimum %g", value, *r.Min)
+	}
+	if r.Max != nil && value > *r.Max {
+		return fmt.Errorf("value %g is above the maximum %g", value, *r.Max)
+	}
+	return nil
+}
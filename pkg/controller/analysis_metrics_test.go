@@ -0,0 +1,185 @@
+package controller
+
+import (
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newPrometheusServer answers every query with the value of the matching entry, the
+// queries are recorded in the order they are received
+func newPrometheusServer(t *testing.T, values map[string]string, queries *[]string) *prometheus.Client {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		*queries = append(*queries, query)
+		value, ok := values[query]
+		if !ok {
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1614556800,"` + value + `"]}]}}`))
+	}))
+	t.Cleanup(ts.Close)
+
+	client, err := prometheus.NewClient(ts.URL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func newMetricsCanary(metrics ...examplev1beta1.CanaryMetric) *examplev1beta1.Canary {
+	return &examplev1beta1.Canary{
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "test"},
+		Spec: examplev1beta1.CanarySpec{
+			Image:     "podinfo:3.1.0",
+			TargetRef: &examplev1beta1.CanaryTargetReference{Name: "podinfo"},
+			Metrics:   metrics,
+		},
+	}
+}
+
+func TestCheckThresholdRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		r     examplev1beta1.CanaryThresholdRange
+		value float64
+		err   string
+	}{
+		{"within range", examplev1beta1.CanaryThresholdRange{Min: floatPtr(1), Max: floatPtr(2)}, 1.5, ""},
+		{"bounds are inclusive", examplev1beta1.CanaryThresholdRange{Min: floatPtr(1), Max: floatPtr(2)}, 2, ""},
+		{"below min", examplev1beta1.CanaryThresholdRange{Min: floatPtr(99)}, 98.5, "below the minimum 99"},
+		{"above max", examplev1beta1.CanaryThresholdRange{Max: floatPtr(500)}, 501, "above the maximum 500"},
+		{"max only", examplev1beta1.CanaryThresholdRange{Max: floatPtr(500)}, -1, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkThresholdRange(tt.r, tt.value)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestQueryMetric_Template(t *testing.T) {
+	var queries []string
+	c := &Controller{prometheus: newPrometheusServer(t, map[string]string{
+		`sum(rate(requests{namespace="test",deployment="podinfo-canary"}[1m]))`: "42",
+	}, &queries)}
+
+	data := MetricQueryData{Name: "podinfo", Namespace: "test", Target: "podinfo", Deployment: "podinfo-canary"}
+	metric := examplev1beta1.CanaryMetric{
+		Name:  "request-rate",
+		Query: `sum(rate(requests{namespace="{{ .Namespace }}",deployment="{{ .Deployment }}"}[1m]))`,
+	}
+	value, err := c.queryMetric(metric, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 42 {
+		t.Errorf("expected 42, got %g", value)
+	}
+
+	metric.Query = `up{pod="{{ .Pod }}"}`
+	if _, err := c.queryMetric(metric, data); err == nil || !strings.Contains(err.Error(), "rendering query failed") {
+		t.Errorf("expected a rendering error for an unknown field, got %v", err)
+	}
+
+	metric.Query = `up{pod="{{ .Name }"}`
+	if _, err := c.queryMetric(metric, data); err == nil || !strings.Contains(err.Error(), "parsing query failed") {
+		t.Errorf("expected a parsing error, got %v", err)
+	}
+
+	if len(queries) != 1 {
+		t.Errorf("expected invalid templates not to be queried, got %v", queries)
+	}
+}
+
+func TestQueryMetric_NoPrometheus(t *testing.T) {
+	c := &Controller{}
+	if _, err := c.queryMetric(examplev1beta1.CanaryMetric{Name: "up", Query: "up"}, MetricQueryData{}); err == nil {
+		t.Error("expected an error without a Prometheus address")
+	}
+}
+
+func TestCheckMetrics(t *testing.T) {
+	var queries []string
+	c := &Controller{prometheus: newPrometheusServer(t, map[string]string{
+		`success_rate{target="podinfo"}`: "99.5",
+		`latency{target="podinfo"}`:      "750",
+	}, &queries)}
+
+	cd := newMetricsCanary(
+		examplev1beta1.CanaryMetric{
+			Name:           "success-rate",
+			Query:          `success_rate{target="{{ .Target }}"}`,
+			ThresholdRange: examplev1beta1.CanaryThresholdRange{Min: floatPtr(99)},
+		},
+		examplev1beta1.CanaryMetric{
+			Name:           "latency",
+			Query:          `latency{target="{{ .Target }}"}`,
+			ThresholdRange: examplev1beta1.CanaryThresholdRange{Max: floatPtr(500)},
+		},
+		examplev1beta1.CanaryMetric{
+			Name:           "missing",
+			Query:          `missing{target="{{ .Target }}"}`,
+			ThresholdRange: examplev1beta1.CanaryThresholdRange{Max: floatPtr(1)},
+		},
+	)
+	dep := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "podinfo-canary", Namespace: "test"}}
+	status := &examplev1beta1.CanaryStatus{}
+
+	err := c.checkMetrics(cd, status, dep)
+	if err == nil || !strings.HasPrefix(err.Error(), "metric latency:") {
+		t.Fatalf("expected the first failed metric to be returned, got %v", err)
+	}
+	if len(queries) != 3 {
+		t.Errorf("expected every metric to be queried, got %v", queries)
+	}
+
+	if len(status.MetricResults) != 3 {
+		t.Fatalf("expected 3 results, got %+v", status.MetricResults)
+	}
+	expected := []struct {
+		name    string
+		value   string
+		passed  bool
+		message string
+	}{
+		{"success-rate", "99.5", true, ""},
+		{"latency", "750", false, "above the maximum 500"},
+		{"missing", "", false, "no values found"},
+	}
+	for i, e := range expected {
+		r := status.MetricResults[i]
+		if r.Name != e.name || r.Value != e.value || r.Passed != e.passed || !strings.Contains(r.Message, e.message) {
+			t.Errorf("expected result %+v, got %+v", e, r)
+		}
+		if r.LastCheckTime.IsZero() {
+			t.Errorf("expected the check time of %s to be set", r.Name)
+		}
+	}
+
+	cd.Spec.Metrics = nil
+	if err := c.checkMetrics(cd, status, dep); err != nil || status.MetricResults != nil {
+		t.Errorf("expected the results to be cleared without metrics, got %v %+v", err, status.MetricResults)
+	}
+}
@@ -6,18 +6,25 @@ import (
 	clientset "github.com/zhouzhihu/k8s-example-crd/pkg/client/clientset/versioned"
 	examplescheme "github.com/zhouzhihu/k8s-example-crd/pkg/client/clientset/versioned/scheme"
 	exampleinformers "github.com/zhouzhihu/k8s-example-crd/pkg/client/informers/externalversions/example/v1beta1"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/metrics"
 	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/prometheus"
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
+	"strings"
 	"sync"
 	"time"
 )
@@ -25,31 +32,53 @@ import (
 const controllerAgentName = "example"
 
 type Controller struct {
-	kubeClient       kubernetes.Interface
-	exampleClient    clientset.Interface
-	exampleInformers Informers
-	exampleSynced    cache.InformerSynced
-	exampleWindow    time.Duration
-	workqueue        workqueue.RateLimitingInterface
-	eventRecorder    record.EventRecorder
-	canaries         *sync.Map
+	kubeClient    kubernetes.Interface
+	exampleClient clientset.Interface
+	newInformers  InformerFactory
+	watch         WatchOptions
+	informersMu   sync.RWMutex
+	informers     map[string]*namespaceInformers
+	exampleWindow time.Duration
+	workqueue     workqueue.RateLimitingInterface
+	eventRecorder record.EventRecorder
+	canaries      *sync.Map
+	notifiers     *sync.Map
+	unwatched     *sync.Map
 	//jobs             		map[string]CanaryJob
-	notifier     notifier.Interface
-	eventWebhook string
-	logger       *zap.SugaredLogger
+	notifier       notifier.Interface
+	notifierClient *notifier.Client
+	templates      *notifier.Templates
+	eventWebhook   string
+	events         chan examplev1beta1.CanaryEventPayload
+	selectorLabels []string
+	prometheus     *prometheus.Client
+	recorder       metrics.Recorder
+	cleanupHooks   []cleanupHook
+	hookCalls      hookCalls
+	logger         *zap.SugaredLogger
 }
 
+// Informers are the informers of a namespace
 type Informers struct {
-	CanaryInformer exampleinformers.CanaryInformer
+	CanaryInformer     exampleinformers.CanaryInformer
+	DeploymentInformer appsinformers.DeploymentInformer
+	// SecretInformer caches the Secrets holding the hook URLs of the canary notifications
+	SecretInformer coreinformers.SecretInformer
 }
 
 func NewController(
 	kubeClient kubernetes.Interface,
 	exampleClient clientset.Interface,
-	exampleInformers Informers,
+	newInformers InformerFactory,
+	watch WatchOptions,
 	exampleWindow time.Duration,
 	notifier notifier.Interface,
+	notifierClient *notifier.Client,
+	templates *notifier.Templates,
 	eventWebhook string,
+	selectorLabels []string,
+	prometheusClient *prometheus.Client,
+	recorder metrics.Recorder,
 	logger *zap.SugaredLogger,
 ) *Controller {
 	logger.Debug("Creating event broadcaster")
@@ -63,54 +92,95 @@ func NewController(
 		scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
 
 	ctrl := &Controller{
-		kubeClient:       kubeClient,
-		exampleClient:    exampleClient,
-		exampleInformers: exampleInformers,
-		exampleSynced:    exampleInformers.CanaryInformer.Informer().HasSynced,
-		exampleWindow:    exampleWindow,
-		workqueue:        workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerAgentName),
-		eventRecorder:    eventRecorder,
-		canaries:         new(sync.Map),
+		kubeClient:    kubeClient,
+		exampleClient: exampleClient,
+		newInformers:  newInformers,
+		watch:         watch,
+		informers:     map[string]*namespaceInformers{},
+		exampleWindow: exampleWindow,
+		workqueue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerAgentName),
+		eventRecorder: eventRecorder,
+		canaries:      new(sync.Map),
+		notifiers:     new(sync.Map),
+		unwatched:     new(sync.Map),
 		//jobs:             map[string]CanaryJob{},
-		notifier:     notifier,
-		eventWebhook: eventWebhook,
-		logger:       logger,
+		notifier:       notifier,
+		notifierClient: notifierClient,
+		templates:      templates,
+		eventWebhook:   eventWebhook,
+		events:         make(chan examplev1beta1.CanaryEventPayload, eventQueueSize),
+		selectorLabels: selectorLabels,
+		prometheus:     prometheusClient,
+		recorder:       recorder,
+		logger:         logger,
 	}
+	ctrl.cleanupHooks = ctrl.defaultCleanupHooks()
 
-	exampleInformers.CanaryInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: ctrl.enqueue,
+	return ctrl
+}
+
+// addEventHandlers enqueues the canaries of a namespace when they or their Deployments change
+func (c *Controller) addEventHandlers(inf Informers) {
+	inf.CanaryInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
 		UpdateFunc: func(old, new interface{}) {
-			oldCanary, ok := checkCustomResourceType(old, logger)
+			oldCanary, ok := checkCustomResourceType(old, c.logger)
 			if !ok {
 				return
 			}
-			newCanary, ok := checkCustomResourceType(new, logger)
+			newCanary, ok := checkCustomResourceType(new, c.logger)
 			if !ok {
 				return
 			}
 			if oldCanary.ResourceVersion == newCanary.ResourceVersion {
 				return
 			}
-			ctrl.enqueue(new)
+			c.enqueue(new)
 		},
 		DeleteFunc: func(old interface{}) {
-			r, ok := checkCustomResourceType(old, logger)
+			r, ok := checkCustomResourceType(old, c.logger)
 			if ok {
-				ctrl.logger.Infof("Deleting %s.%s from cache", r.Name, r.Namespace)
-				ctrl.canaries.Delete(fmt.Sprintf("%s.%s", r.Name, r.Namespace))
+				c.logger.Infof("Deleting %s.%s from cache", r.Name, r.Namespace)
+				c.canaries.Delete(fmt.Sprintf("%s.%s", r.Name, r.Namespace))
+				c.notifiers.Delete(fmt.Sprintf("%s.%s", r.Name, r.Namespace))
+				c.recorder.DeleteCanary(r.Name, r.Namespace)
+				c.forgetHooks(&r)
 			}
 		},
 	})
 
-	return ctrl
+	// the Deployments are garbage collected through their owner reference when
+	// the canary is deleted, events are only used to revert manual changes
+	inf.DeploymentInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handleDeployment,
+		UpdateFunc: func(old, new interface{}) {
+			if old.(metav1.Object).GetResourceVersion() == new.(metav1.Object).GetResourceVersion() {
+				return
+			}
+			c.handleDeployment(new)
+		},
+		DeleteFunc: c.handleDeployment,
+	})
 }
 
-func (c Controller) Run(threadiness int, stopCh <-chan struct{}) error {
+// Run starts the namespace informers, the event publisher and threadiness workers,
+// it blocks until stopCh is closed and the queued events are published
+func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
 	defer c.workqueue.ShutDown()
 
 	c.logger.Info("Starting operator")
 
+	if err := c.startNamespaces(stopCh); err != nil {
+		return err
+	}
+
+	published := make(chan struct{})
+	go func() {
+		c.publishEvents(stopCh)
+		close(published)
+	}()
+
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(func() {
 			for c.processNextWorkItem() {
@@ -120,10 +190,10 @@ func (c Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 	c.logger.Info("Started operator workers")
 
-	// TODO 对照代码，需要理解
-	c.logger.Info("Started workers")
+	// the workers stop with stopCh, the deferred shutdown of the queue ends their loop
 	<-stopCh
 	c.logger.Info("Shutting down operator workers")
+	<-published
 
 	return nil
 }
@@ -163,23 +233,161 @@ func (c *Controller) processNextWorkItem() bool {
 	return true
 }
 
-// TODO 对照代码，需要理解
-func (c *Controller) syncHandler(key string) error {
+// syncHandler reconciles the canary of the namespace/name key taken from the work queue
+// with its Deployments and writes the outcome to its status, a rollout that waits
+// re-queues the canary with enqueueAfter
+func (c *Controller) syncHandler(key string) (err error) {
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
 		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
 		return nil
 	}
-	cd, err := c.exampleInformers.CanaryInformer.Lister().Canaries(namespace).Get(name)
+	inf, ok := c.informersFor(namespace)
+	if !ok {
+		if released, ok := c.unwatched.Load(key); ok {
+			return c.release(key, released.(Informers), namespace, name)
+		}
+		c.logger.Debugf("Namespace of %s is no longer watched", key)
+		return nil
+	}
+	// the namespace is watched again before the canary was released
+	c.unwatched.Delete(key)
+	if !c.informersSynced(namespace) {
+		c.workqueue.AddAfter(key, time.Second)
+		return nil
+	}
+	cd, err := inf.CanaryInformer.Lister().Canaries(namespace).Get(name)
 	if errors.IsNotFound(err) {
 		utilruntime.HandleError(fmt.Errorf("%s in work queue no longer exists", key))
 		return nil
 	}
+	if err != nil {
+		return err
+	}
+
+	if cd.DeletionTimestamp != nil {
+		return c.finalize(cd)
+	}
 
-	c.recordEventInfof(cd, "Successed canary %s.%s", cd.Name, cd.Namespace)
+	if err := c.ensureFinalizer(cd); err != nil {
+		return err
+	}
+
+	status := *cd.Status.DeepCopy()
+
+	begin := time.Now()
+	defer func() {
+		c.recorder.IncReconcile(cd, time.Since(begin), err)
+		c.recorder.SetStatus(cd, status.Phase)
+	}()
+
+	// mark the canary as seen before doing any work
+	if status.Phase == "" {
+		setStatusPhase(&status, examplev1beta1.CanaryPhaseInitializing)
+		setStatusCondition(cd, &status, examplev1beta1.CanaryConditionReady, metav1.ConditionUnknown,
+			"Initializing", "Canary is being initialized")
+		if err := c.syncStatus(cd, status); err != nil {
+			return err
+		}
+	}
 
 	c.canaries.Store(fmt.Sprintf("%s.%s", cd.Name, cd.Namespace), cd)
 
+	// a suspended canary keeps its deployments and its rollout progress as they are
+	if isSuspended(cd) {
+		c.pause(cd, &status, ReasonSuspended, "spec.suspend is set")
+		status.ObservedGeneration = cd.Generation
+		return c.syncStatus(cd, status)
+	}
+	c.resume(cd, &status, ReasonSuspended)
+
+	if ok := c.syncSchedule(cd, &status); !ok {
+		status.ObservedGeneration = cd.Generation
+		return c.syncStatus(cd, status)
+	}
+
+	if cd.Spec.Image == "" {
+		if !hasStatusCondition(cd, &status, examplev1beta1.CanaryConditionReady, metav1.ConditionFalse, ReasonInvalidSpec) {
+			c.recordEventWarningf(cd, ReasonInvalidSpec, "Canary %s.%s has no image, spec.image is required", cd.Name, cd.Namespace)
+		}
+		setStatusPhase(&status, examplev1beta1.CanaryPhaseFailed)
+		setStatusCondition(cd, &status, examplev1beta1.CanaryConditionReady, metav1.ConditionFalse,
+			ReasonInvalidSpec, "spec.image is required to reconcile the deployment")
+		status.ObservedGeneration = cd.Generation
+		return c.syncStatus(cd, status)
+	}
+
+	selector, ok := c.podSelector(cd)
+	if !ok {
+		message := fmt.Sprintf("none of the selector labels %s is set on the canary", strings.Join(c.selectorLabels, ", "))
+		if !hasStatusCondition(cd, &status, examplev1beta1.CanaryConditionSelected, metav1.ConditionFalse, ReasonSelectorLabelMissing) {
+			c.recordEventWarningf(cd, ReasonSelectorLabelMissing, "Canary %s.%s has no selector label, %s", cd.Name, cd.Namespace, message)
+		}
+		setStatusPhase(&status, examplev1beta1.CanaryPhaseFailed)
+		setStatusCondition(cd, &status, examplev1beta1.CanaryConditionSelected, metav1.ConditionFalse,
+			ReasonSelectorLabelMissing, message)
+		setStatusCondition(cd, &status, examplev1beta1.CanaryConditionReady, metav1.ConditionFalse,
+			ReasonSelectorLabelMissing, message)
+		status.ObservedGeneration = cd.Generation
+		return c.syncStatus(cd, status)
+	}
+	setStatusCondition(cd, &status, examplev1beta1.CanaryConditionSelected, metav1.ConditionTrue,
+		ReasonSynced, fmt.Sprintf("Pods are selected with %s", labels.SelectorFromSet(selector)))
+
+	if cd.Spec.TargetRef != nil {
+		return c.syncRollout(cd, &status, selector)
+	}
+
+	// a rolled back deployment holds the stable image until the spec changes
+	if isRolledBack(cd, &status) {
+		status.ObservedGeneration = cd.Generation
+		return c.syncStatus(cd, status)
+	}
+
+	dep, ready, err := c.syncDeployment(cd, selector)
+	if err != nil {
+		if c.requeueOnConflict(cd, err) {
+			return nil
+		}
+		c.recordEventErrorf(cd, ReasonDeploymentSyncFailed, "Deployment %s.%s sync failed: %v", cd.Name, cd.Namespace, err)
+		setStatusPhase(&status, examplev1beta1.CanaryPhaseFailed)
+		setStatusCondition(cd, &status, examplev1beta1.CanaryConditionReady, metav1.ConditionFalse,
+			ReasonDeploymentSyncFailed, err.Error())
+		if err := c.syncStatus(cd, status); err != nil {
+			return err
+		}
+		return err
+	}
+
+	status.ObservedGeneration = cd.Generation
+	if !ready {
+		check := func() error { return c.checkDeployment(dep) }
+		if status.StableImage != "" && status.StableImage != cd.Spec.Image && c.analyse(cd, &status, check) {
+			return c.rollbackDeployment(cd, &status, dep)
+		}
+		// the deployment informer re-queues the canary while the rollout progresses,
+		// the checks re-queue it as crash-looping pods do not always update the deployment
+		c.enqueueAfter(cd, analysisInterval(cd))
+		setStatusPhase(&status, examplev1beta1.CanaryPhaseProgressing)
+		setStatusCondition(cd, &status, examplev1beta1.CanaryConditionReady, metav1.ConditionFalse,
+			"Progressing", fmt.Sprintf("Deployment %s.%s rollout in progress", cd.Name, cd.Namespace))
+		return c.syncStatus(cd, status)
+	}
+
+	if status.Phase != examplev1beta1.CanaryPhaseSucceeded {
+		c.recordEventInfof(cd, ReasonSucceeded, "Successed canary %s.%s", cd.Name, cd.Namespace)
+		c.alert(cd, notifier.EventSucceeded, fmt.Sprintf("Rollout of %s succeeded", cd.Spec.Image), notifier.SeverityInfo)
+	}
+	status.StableImage = cd.Spec.Image
+	status.FailedChecks = 0
+	status.LastCheckTime = nil
+	setStatusPhase(&status, examplev1beta1.CanaryPhaseSucceeded)
+	setStatusCondition(cd, &status, examplev1beta1.CanaryConditionReady, metav1.ConditionTrue,
+		ReasonSynced, "Canary reconciled successfully")
+	if err := c.syncStatus(cd, status); err != nil {
+		return err
+	}
+
 	c.logger.Infof("Synced %s", key)
 
 	return nil
@@ -0,0 +1,462 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/client/clientset/versioned/fake"
+	informers "github.com/zhouzhihu/k8s-example-crd/pkg/client/informers/externalversions"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/metrics"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testKey = "test/podinfo"
+
+// recordingNotifier records the posted messages
+type recordingNotifier struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (r *recordingNotifier) Post(workload string, namespace string, message string, fields []notifier.Field, severity string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, severity+": "+message)
+	return nil
+}
+
+func (r *recordingNotifier) posted() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.messages...)
+}
+
+// fixture runs the controller against fake clientsets, the informers are not
+// started, their caches are filled from the clientsets by syncCache
+type fixture struct {
+	t             *testing.T
+	kubeClient    *k8sfake.Clientset
+	exampleClient *fake.Clientset
+	inf           Informers
+	ctrl          *Controller
+	events        *record.FakeRecorder
+	notifier      *recordingNotifier
+}
+
+func newFixture(t *testing.T, objects ...runtime.Object) *fixture {
+	var kubeObjects, exampleObjects []runtime.Object
+	for _, obj := range objects {
+		if _, ok := obj.(*examplev1beta1.Canary); ok {
+			exampleObjects = append(exampleObjects, obj)
+		} else {
+			kubeObjects = append(kubeObjects, obj)
+		}
+	}
+
+	f := &fixture{
+		t:             t,
+		kubeClient:    k8sfake.NewSimpleClientset(kubeObjects...),
+		exampleClient: fake.NewSimpleClientset(exampleObjects...),
+		events:        record.NewFakeRecorder(100),
+		notifier:      &recordingNotifier{},
+	}
+	f.inf = Informers{
+		CanaryInformer:     informers.NewSharedInformerFactory(f.exampleClient, 0).Example().V1beta1().Canaries(),
+		DeploymentInformer: kubeinformers.NewSharedInformerFactory(f.kubeClient, 0).Apps().V1().Deployments(),
+		SecretInformer:     kubeinformers.NewSharedInformerFactory(f.kubeClient, 0).Core().V1().Secrets(),
+	}
+
+	// the API server bumps the generation of a Deployment when its spec changes
+	f.kubeClient.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		update := action.(k8stesting.UpdateAction)
+		if update.GetSubresource() != "" {
+			return false, nil, nil
+		}
+		dep := update.GetObject().(*appsv1.Deployment)
+		old, err := f.kubeClient.Tracker().Get(appsv1.SchemeGroupVersion.WithResource("deployments"), dep.Namespace, dep.Name)
+		if err == nil && !equality.Semantic.DeepEqual(old.(*appsv1.Deployment).Spec, dep.Spec) {
+			dep.Generation = old.(*appsv1.Deployment).Generation + 1
+		}
+		return false, nil, nil
+	})
+
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerAgentName)
+	t.Cleanup(queue.ShutDown)
+
+	f.ctrl = &Controller{
+		kubeClient:     f.kubeClient,
+		exampleClient:  f.exampleClient,
+		watch:          WatchOptions{Namespaces: []string{"test"}},
+		informers:      map[string]*namespaceInformers{"test": {Informers: f.inf, done: make(chan struct{})}},
+		workqueue:      queue,
+		eventRecorder:  f.events,
+		canaries:       new(sync.Map),
+		notifiers:      new(sync.Map),
+		unwatched:      new(sync.Map),
+		notifier:       f.notifier,
+		selectorLabels: []string{"app"},
+		recorder:       metrics.NewRecorder("test", false),
+		logger:         zap.NewNop().Sugar(),
+	}
+	f.ctrl.cleanupHooks = f.ctrl.defaultCleanupHooks()
+
+	f.syncCache()
+	return f
+}
+
+// syncCache plays the informers, the caches are replaced by the objects of the clientsets
+func (f *fixture) syncCache() {
+	f.syncCanaries()
+	deps, err := f.kubeClient.AppsV1().Deployments("test").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	var items []interface{}
+	for i := range deps.Items {
+		items = append(items, &deps.Items[i])
+	}
+	if err := f.inf.DeploymentInformer.Informer().GetIndexer().Replace(items, ""); err != nil {
+		f.t.Fatal(err)
+	}
+	secrets, err := f.kubeClient.CoreV1().Secrets("test").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	items = nil
+	for i := range secrets.Items {
+		items = append(items, &secrets.Items[i])
+	}
+	if err := f.inf.SecretInformer.Informer().GetIndexer().Replace(items, ""); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// syncCanaries refreshes the cache of the canaries only, the Deployments are left stale
+func (f *fixture) syncCanaries() {
+	cds, err := f.exampleClient.ExampleV1beta1().Canaries("test").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	var items []interface{}
+	for i := range cds.Items {
+		items = append(items, &cds.Items[i])
+	}
+	if err := f.inf.CanaryInformer.Informer().GetIndexer().Replace(items, ""); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// sync runs the sync handler and refreshes the caches with its changes
+func (f *fixture) sync() {
+	f.t.Helper()
+	if err := f.ctrl.syncHandler(testKey); err != nil {
+		f.t.Fatal(err)
+	}
+	f.syncCache()
+}
+
+func (f *fixture) canary() *examplev1beta1.Canary {
+	f.t.Helper()
+	cd, err := f.exampleClient.ExampleV1beta1().Canaries("test").Get(context.TODO(), "podinfo", metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return cd
+}
+
+// updateCanary changes the canary in the clientset and in the cache
+func (f *fixture) updateCanary(mutate func(cd *examplev1beta1.Canary)) {
+	f.t.Helper()
+	cd := f.canary()
+	mutate(cd)
+	if _, err := f.exampleClient.ExampleV1beta1().Canaries("test").Update(context.TODO(), cd, metav1.UpdateOptions{}); err != nil {
+		f.t.Fatal(err)
+	}
+	f.syncCanaries()
+}
+
+// deployment returns nil when the Deployment does not exist
+func (f *fixture) deployment(name string) *appsv1.Deployment {
+	f.t.Helper()
+	dep, err := f.kubeClient.AppsV1().Deployments("test").Get(context.TODO(), name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return dep
+}
+
+// rollOut plays the Deployment controller, every replica of the latest generation becomes available
+func (f *fixture) rollOut(name string) {
+	f.t.Helper()
+	dep := f.deployment(name)
+	markReady(dep)
+	if _, err := f.kubeClient.AppsV1().Deployments("test").UpdateStatus(context.TODO(), dep, metav1.UpdateOptions{}); err != nil {
+		f.t.Fatal(err)
+	}
+	f.syncCache()
+}
+
+// conflictOnUpdate fails the next updates of the resource with a conflict, forever when times is negative
+func (f *fixture) conflictOnUpdate(resource string, times int) {
+	f.kubeClient.PrependReactor("update", resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "" || times == 0 {
+			return false, nil, nil
+		}
+		times--
+		obj := action.(k8stesting.UpdateAction).GetObject().(metav1.Object)
+		return true, nil, errors.NewConflict(action.GetResource().GroupResource(), obj.GetName(),
+			fmt.Errorf("the object has been modified"))
+	})
+}
+
+// recordedEvents returns the type and reason of the events recorded since the last call
+func (f *fixture) recordedEvents() []string {
+	var events []string
+	for {
+		select {
+		case e := <-f.events.Events:
+			fields := strings.SplitN(e, " ", 3)
+			events = append(events, fields[0]+" "+fields[1])
+		default:
+			return events
+		}
+	}
+}
+
+func hasEvent(events []string, event string) bool {
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func hasWarning(events []string) bool {
+	for _, e := range events {
+		if strings.HasPrefix(e, corev1.EventTypeWarning) {
+			return true
+		}
+	}
+	return false
+}
+
+func readyCondition(cd *examplev1beta1.Canary) metav1.Condition {
+	cond := meta.FindStatusCondition(cd.Status.Conditions, examplev1beta1.CanaryConditionReady)
+	if cond == nil {
+		return metav1.Condition{}
+	}
+	return *cond
+}
+
+func markReady(dep *appsv1.Deployment) {
+	replicas := *dep.Spec.Replicas
+	dep.Status.ObservedGeneration = dep.Generation
+	dep.Status.Replicas = replicas
+	dep.Status.UpdatedReplicas = replicas
+	dep.Status.AvailableReplicas = replicas
+}
+
+// newCanary returns a canary managing its own Deployment
+func newCanary(image string) *examplev1beta1.Canary {
+	return &examplev1beta1.Canary{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "podinfo",
+			Namespace:  "test",
+			Generation: 1,
+			Labels:     map[string]string{"app": "podinfo"},
+		},
+		Spec: examplev1beta1.CanarySpec{
+			Image:    image,
+			Cron:     "0 0 1 1 *",
+			Replicas: 4,
+		},
+	}
+}
+
+func TestSyncHandler_Deployment(t *testing.T) {
+	owned := func(mutate func(dep *appsv1.Deployment)) *appsv1.Deployment {
+		dep := newDeployment(newCanary("podinfo:2.0"), map[string]string{"app": "podinfo"})
+		mutate(dep)
+		return dep
+	}
+
+	tests := []struct {
+		name      string
+		dep       *appsv1.Deployment
+		conflicts int
+		phase     examplev1beta1.CanaryPhase
+		event     string
+	}{
+		{"created", nil, 0, examplev1beta1.CanaryPhaseProgressing, "Normal DeploymentCreated"},
+		{"image drifted", owned(func(dep *appsv1.Deployment) {
+			dep.Spec.Template.Spec.Containers[0].Image = "podinfo:1.0"
+		}), 0, examplev1beta1.CanaryPhaseProgressing, "Normal DeploymentUpdated"},
+		{"replicas drifted", owned(func(dep *appsv1.Deployment) {
+			replicas := int32(1)
+			dep.Spec.Replicas = &replicas
+		}), 0, examplev1beta1.CanaryPhaseProgressing, "Normal DeploymentUpdated"},
+		{"update retried on conflict", owned(func(dep *appsv1.Deployment) {
+			dep.Spec.Template.Spec.Containers[0].Image = "podinfo:1.0"
+		}), 1, examplev1beta1.CanaryPhaseProgressing, "Normal DeploymentUpdated"},
+		{"conflicts re-queue the canary", owned(func(dep *appsv1.Deployment) {
+			dep.Spec.Template.Spec.Containers[0].Image = "podinfo:1.0"
+		}), -1, examplev1beta1.CanaryPhaseInitializing, ""},
+		{"ready", owned(markReady), 0, examplev1beta1.CanaryPhaseSucceeded, "Normal Succeeded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []runtime.Object{newCanary("podinfo:2.0")}
+			if tt.dep != nil {
+				objects = append(objects, tt.dep)
+			}
+			f := newFixture(t, objects...)
+			f.conflictOnUpdate("deployments", tt.conflicts)
+
+			f.sync()
+
+			got := f.canary()
+			if got.Status.Phase != tt.phase {
+				t.Errorf("expected phase %s, got %s (%+v)", tt.phase, got.Status.Phase, readyCondition(got))
+			}
+			events := f.recordedEvents()
+			if tt.event != "" && !hasEvent(events, tt.event) {
+				t.Errorf("expected event %s, got %v", tt.event, events)
+			}
+			if hasWarning(events) {
+				t.Errorf("expected no warning, got %v", events)
+			}
+
+			dep := f.deployment("podinfo")
+			if tt.conflicts < 0 {
+				if dep.Spec.Template.Spec.Containers[0].Image != "podinfo:1.0" {
+					t.Errorf("expected the deployment to be left as it is, got %s", dep.Spec.Template.Spec.Containers[0].Image)
+				}
+				return
+			}
+			if dep.Spec.Template.Spec.Containers[0].Image != "podinfo:2.0" || *dep.Spec.Replicas != 4 {
+				t.Errorf("expected podinfo:2.0 with 4 replicas, got %s with %d",
+					dep.Spec.Template.Spec.Containers[0].Image, *dep.Spec.Replicas)
+			}
+		})
+	}
+}
+
+func TestSyncHandler_AddsFinalizer(t *testing.T) {
+	f := newFixture(t, newCanary("podinfo:2.0"))
+	f.sync()
+
+	if !hasFinalizer(f.canary()) {
+		t.Errorf("expected the finalizer to be added, got %v", f.canary().Finalizers)
+	}
+}
+
+func TestSyncHandler_DeploymentKeepsUnmanagedFields(t *testing.T) {
+	dep := newDeployment(newCanary("podinfo:2.0"), map[string]string{"app": "podinfo"})
+	dep.Spec.Template.Spec.Containers[0].Image = "podinfo:1.0"
+	dep.Spec.Template.Spec.Containers[0].Args = []string{"--port=9898"}
+	dep.Spec.Template.Spec.Containers = append(dep.Spec.Template.Spec.Containers, corev1.Container{Name: "proxy", Image: "envoy:1.17"})
+	f := newFixture(t, newCanary("podinfo:2.0"), dep)
+
+	f.sync()
+
+	containers := f.deployment("podinfo").Spec.Template.Spec.Containers
+	if len(containers) != 2 || containers[1].Image != "envoy:1.17" {
+		t.Fatalf("expected the sidecar to be kept, got %+v", containers)
+	}
+	if containers[0].Image != "podinfo:2.0" || len(containers[0].Args) != 1 {
+		t.Errorf("expected only the image of the first container to be updated, got %+v", containers[0])
+	}
+}
+
+func TestRollbackDeployment_WithoutContainers(t *testing.T) {
+	cd := newCanary("podinfo:2.0")
+	dep := newDeployment(cd, map[string]string{"app": "podinfo"})
+	dep.Spec.Template.Spec.Containers = nil
+	f := newFixture(t, cd, dep)
+
+	status := examplev1beta1.CanaryStatus{StableImage: "podinfo:1.0", FailedChecks: 2}
+	if err := f.ctrl.rollbackDeployment(cd, &status, dep); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.deployment("podinfo"); len(got.Spec.Template.Spec.Containers) != 1 || image(got) != "podinfo:1.0" {
+		t.Errorf("expected the stable image to be restored, got %+v", got.Spec.Template.Spec.Containers)
+	}
+}
+
+func TestNotifierFor_ReadsSecretFromCache(t *testing.T) {
+	var posted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = append(posted, r.URL.Path)
+	}))
+	t.Cleanup(server.Close)
+
+	cd := newCanary("podinfo:2.0")
+	cd.Spec.Notifications = &examplev1beta1.CanaryNotifications{
+		Provider: "generic",
+		SecretRef: corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "hooks"},
+			Key:                  "address",
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "hooks", Namespace: "test", ResourceVersion: "1"},
+		Data:       map[string][]byte{"address": []byte(server.URL + "/first")},
+	}
+	f := newFixture(t, cd, secret)
+	noSecretReads := func() {
+		t.Helper()
+		for _, action := range f.kubeClient.Actions() {
+			if action.GetResource().Resource == "secrets" {
+				t.Errorf("expected the Secret to be read from the cache, got %s", action.GetVerb())
+			}
+		}
+	}
+	f.kubeClient.ClearActions()
+
+	n := f.ctrl.notifierFor(cd)
+	if err := n.Post("podinfo", "test", "started", nil, notifier.SeverityInfo); err != nil {
+		t.Fatal(err)
+	}
+	noSecretReads()
+
+	// the rotated Secret is read from the cache once the informer saw the update
+	secret.ResourceVersion = "2"
+	secret.Data["address"] = []byte(server.URL + "/second")
+	if _, err := f.kubeClient.CoreV1().Secrets("test").Update(context.TODO(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	f.syncCache()
+	f.kubeClient.ClearActions()
+	if err := n.Post("podinfo", "test", "succeeded", nil, notifier.SeverityInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	noSecretReads()
+
+	if len(posted) != 2 || posted[0] != "/first" || posted[1] != "/second" {
+		t.Errorf("expected the notifications to follow the rotated Secret, got %v", posted)
+	}
+}
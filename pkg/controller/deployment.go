@@ -0,0 +1,272 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"time"
+)
+
+// syncDeployment creates the Deployment owned by the canary or brings it back
+// in line with the canary spec when it has drifted, it returns the Deployment
+// and true when it has finished rolling out
+func (c *Controller) syncDeployment(cd *examplev1beta1.Canary, selector map[string]string) (*appsv1.Deployment, bool, error) {
+	desired := newDeployment(cd, selector)
+
+	inf, ok := c.informersFor(cd.Namespace)
+	if !ok {
+		return nil, false, fmt.Errorf("namespace %s is not watched", cd.Namespace)
+	}
+	dep, err := inf.DeploymentInformer.Lister().Deployments(cd.Namespace).Get(cd.Name)
+	if errors.IsNotFound(err) {
+		dep, err = c.kubeClient.AppsV1().Deployments(cd.Namespace).Create(context.TODO(), desired, metav1.CreateOptions{})
+		if err != nil {
+			return nil, false, fmt.Errorf("deployment %s.%s create error: %w", desired.Name, desired.Namespace, err)
+		}
+		c.recordEventInfof(cd, ReasonDeploymentCreated, "Deployment %s.%s created", desired.Name, desired.Namespace)
+		c.alert(cd, notifier.EventStarted, fmt.Sprintf("Rollout of %s started", cd.Spec.Image), notifier.SeverityInfo)
+		return dep, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("deployment %s.%s get query error: %w", cd.Name, cd.Namespace, err)
+	}
+
+	if !metav1.IsControlledBy(dep, cd) {
+		return nil, false, fmt.Errorf("deployment %s.%s already exists and is not managed by canary %s.%s",
+			dep.Name, dep.Namespace, cd.Name, cd.Namespace)
+	}
+
+	// the selector of a Deployment is immutable, it has to be recreated
+	if !equality.Semantic.DeepEqual(dep.Spec.Selector, desired.Spec.Selector) {
+		return nil, false, fmt.Errorf("deployment %s.%s selector %s does not match %s, delete the deployment to recreate it",
+			dep.Name, dep.Namespace, metav1.FormatLabelSelector(dep.Spec.Selector), metav1.FormatLabelSelector(desired.Spec.Selector))
+	}
+
+	if hasDeploymentDrifted(dep, desired) {
+		dep, err = c.updateDeployment(dep.Namespace, dep.Name, func(dep *appsv1.Deployment) bool {
+			if !hasDeploymentDrifted(dep, desired) {
+				return false
+			}
+			dep.Spec.Replicas = desired.Spec.Replicas
+			// only the first container is managed, injected sidecars and resources set by hand are kept
+			want := desired.Spec.Template.Spec.Containers[0]
+			if len(dep.Spec.Template.Spec.Containers) == 0 {
+				dep.Spec.Template.Spec.Containers = []corev1.Container{want}
+			} else {
+				dep.Spec.Template.Spec.Containers[0].Name = want.Name
+				dep.Spec.Template.Spec.Containers[0].Image = want.Image
+			}
+			for k, v := range desired.Spec.Template.Labels {
+				if dep.Spec.Template.Labels == nil {
+					dep.Spec.Template.Labels = map[string]string{}
+				}
+				dep.Spec.Template.Labels[k] = v
+			}
+			return true
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("deployment %s.%s update error: %w", desired.Name, desired.Namespace, err)
+		}
+		c.recordEventInfof(cd, ReasonDeploymentUpdated, "Deployment %s.%s updated to image %s and %d replicas",
+			dep.Name, dep.Namespace, cd.Spec.Image, cd.Spec.Replicas)
+		c.alert(cd, notifier.EventStarted, fmt.Sprintf("Rollout of %s started", cd.Spec.Image), notifier.SeverityInfo)
+		return dep, false, nil
+	}
+
+	return dep, isDeploymentReady(dep), nil
+}
+
+// rollbackDeployment reverts the Deployment owned by the canary to the stable image
+func (c *Controller) rollbackDeployment(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, dep *appsv1.Deployment) error {
+	_, err := c.updateDeployment(dep.Namespace, dep.Name, func(dep *appsv1.Deployment) bool {
+		containers := dep.Spec.Template.Spec.Containers
+		if len(containers) == 0 {
+			dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: cd.Name, Image: status.StableImage}}
+			return true
+		}
+		if containers[0].Image == status.StableImage {
+			return false
+		}
+		containers[0].Image = status.StableImage
+		return true
+	})
+	if err != nil {
+		if c.requeueOnConflict(cd, err) {
+			return nil
+		}
+		return fmt.Errorf("deployment %s.%s update error: %w", dep.Name, dep.Namespace, err)
+	}
+	return c.markRolledBack(cd, status, dep.Name, failedChecksCause(status))
+}
+
+// updateDeployment applies mutate to a copy of the latest version of the Deployment and
+// updates it when mutate returns true, the update is retried on conflicts as the Deployment
+// controller keeps changing the Deployment during a rollout
+func (c *Controller) updateDeployment(namespace, name string, mutate func(dep *appsv1.Deployment) bool) (*appsv1.Deployment, error) {
+	var result *appsv1.Deployment
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		latest, err := c.kubeClient.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		depCopy := latest.DeepCopy()
+		if !mutate(depCopy) {
+			result = latest
+			return nil
+		}
+		result, err = c.kubeClient.AppsV1().Deployments(namespace).Update(context.TODO(), depCopy, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// requeueOnConflict re-queues the canary when the error is a conflict that outlasted
+// the retries, conflicts are routine during a rollout and are not reported as failures
+func (c *Controller) requeueOnConflict(cd *examplev1beta1.Canary, err error) bool {
+	if !errors.IsConflict(err) {
+		return false
+	}
+	c.logger.With("canary", fmt.Sprintf("%s.%s", cd.Name, cd.Namespace)).Debugf("Retrying after a conflict: %v", err)
+	c.enqueueAfter(cd, time.Second)
+	return true
+}
+
+// podSelector returns the first configured selector label set on the canary,
+// false is returned when the canary has none of them
+func (c *Controller) podSelector(cd *examplev1beta1.Canary) (map[string]string, bool) {
+	for _, key := range c.selectorLabels {
+		if value := cd.Labels[key]; value != "" {
+			return map[string]string{key: value}, true
+		}
+	}
+	return nil, false
+}
+
+// newDeployment builds the Deployment for a canary selecting its pods with the selector
+func newDeployment(cd *examplev1beta1.Canary, selector map[string]string) *appsv1.Deployment {
+	labels := map[string]string{}
+	for k, v := range selector {
+		labels[k] = v
+	}
+	replicas := cd.Spec.Replicas
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cd.Name,
+			Namespace: cd.Namespace,
+			Labels:    labels,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(cd, examplev1beta1.SchemeGroupVersion.WithKind("Canary")),
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  cd.Name,
+							Image: cd.Spec.Image,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// hasDeploymentDrifted compares the fields managed by the controller
+func hasDeploymentDrifted(dep, desired *appsv1.Deployment) bool {
+	if dep.Spec.Replicas == nil || *dep.Spec.Replicas != *desired.Spec.Replicas {
+		return true
+	}
+	for k, v := range desired.Spec.Template.Labels {
+		if dep.Spec.Template.Labels[k] != v {
+			return true
+		}
+	}
+	containers := dep.Spec.Template.Spec.Containers
+	want := desired.Spec.Template.Spec.Containers[0]
+	if len(containers) == 0 || containers[0].Name != want.Name || containers[0].Image != want.Image {
+		return true
+	}
+	return false
+}
+
+// isDeploymentReady returns true when the latest generation has been rolled out
+// and all replicas are updated and available
+func isDeploymentReady(dep *appsv1.Deployment) bool {
+	if dep.Generation > dep.Status.ObservedGeneration {
+		return false
+	}
+	var replicas int32 = 1
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	return dep.Status.UpdatedReplicas == replicas &&
+		dep.Status.AvailableReplicas == replicas &&
+		dep.Status.Replicas == replicas
+}
+
+// handleDeployment enqueues the canary that owns or targets the Deployment so
+// that manual edits and rollout progress trigger a reconcile
+func (c *Controller) handleDeployment(obj interface{}) {
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		object, ok = tombstone.Obj.(metav1.Object)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+	}
+
+	inf, ok := c.informersFor(object.GetNamespace())
+	if !ok {
+		return
+	}
+
+	ownerRef := metav1.GetControllerOf(object)
+	if ownerRef == nil || ownerRef.Kind != "Canary" {
+		// the target Deployments of progressive rollouts are not owned by their canaries
+		canaries, err := inf.CanaryInformer.Lister().Canaries(object.GetNamespace()).List(labels.Everything())
+		if err != nil {
+			return
+		}
+		for _, cd := range canaries {
+			if cd.Spec.TargetRef != nil && cd.Spec.TargetRef.Name == object.GetName() {
+				c.enqueue(cd)
+			}
+		}
+		return
+	}
+
+	cd, err := inf.CanaryInformer.Lister().Canaries(object.GetNamespace()).Get(ownerRef.Name)
+	if err != nil {
+		return
+	}
+	c.enqueue(cd)
+}
@@ -3,12 +3,85 @@ package controller
 import (
 	"fmt"
 	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
 	corev1 "k8s.io/api/core/v1"
 )
 
-func (c *Controller) recordEventInfof(r *examplev1beta1.Canary, template string, args ...interface{}) {
+// Reasons used for the events recorded on canaries
+const (
+	ReasonSynced               = "Synced"
+	ReasonInvalidSpec          = "InvalidSpec"
+	ReasonInvalidSchedule      = "InvalidSchedule"
+	ReasonSelectorLabelMissing = "SelectorLabelMissing"
+	ReasonDeploymentSyncFailed = "DeploymentSyncFailed"
+	ReasonCheckFailed          = "CheckFailed"
+	ReasonRolledBack           = "RolledBack"
+	ReasonStatusUpdateFailed   = "StatusUpdateFailed"
+	ReasonCleanupFailed        = "CleanupFailed"
+	ReasonDeploymentCreated    = "DeploymentCreated"
+	ReasonDeploymentUpdated    = "DeploymentUpdated"
+	ReasonScheduled            = "Scheduled"
+	ReasonRolloutStarted       = "RolloutStarted"
+	ReasonAdvanced             = "Advanced"
+	ReasonApproved             = "Approved"
+	ReasonPromoting            = "Promoting"
+	ReasonSucceeded            = "Succeeded"
+	ReasonDeleted              = "Deleted"
+)
+
+func (c *Controller) recordEventInfof(r *examplev1beta1.Canary, reason string, template string, args ...interface{}) {
 	c.logger.With("canary", fmt.Sprintf("%s.%s", r.Name, r.Namespace)).Infof(template, args...)
-	c.eventRecorder.Event(r, corev1.EventTypeNormal, "Synced", fmt.Sprintf(template, args...))
-	// TODO
-	//c.sendEventToWebhook(r, corev1.EventTypeNormal, template, args)
+	c.eventRecorder.Event(r, corev1.EventTypeNormal, reason, fmt.Sprintf(template, args...))
+	c.sendEventToWebhook(r, corev1.EventTypeNormal, reason, template, args)
+}
+
+func (c *Controller) recordEventWarningf(r *examplev1beta1.Canary, reason string, template string, args ...interface{}) {
+	c.logger.With("canary", fmt.Sprintf("%s.%s", r.Name, r.Namespace)).Warnf(template, args...)
+	c.eventRecorder.Event(r, corev1.EventTypeWarning, reason, fmt.Sprintf(template, args...))
+	c.sendEventToWebhook(r, corev1.EventTypeWarning, reason, template, args)
+}
+
+// recordEventErrorf records a warning event and alerts the notifier with error severity
+func (c *Controller) recordEventErrorf(r *examplev1beta1.Canary, reason string, template string, args ...interface{}) {
+	c.logger.With("canary", fmt.Sprintf("%s.%s", r.Name, r.Namespace)).Errorf(template, args...)
+	c.eventRecorder.Event(r, corev1.EventTypeWarning, reason, fmt.Sprintf(template, args...))
+	c.sendEventToWebhook(r, corev1.EventTypeWarning, reason, template, args)
+	c.alert(r, notifier.EventFailed, fmt.Sprintf(template, args...), notifier.SeverityError)
+}
+
+// alert posts the message rendered from the template of the event type
+func (c *Controller) alert(r *examplev1beta1.Canary, event string, message string, severity string) {
+	fields := []notifier.Field{
+		{
+			Name:  "Image",
+			Value: r.Spec.Image,
+		},
+		{
+			Name:  "Replicas",
+			Value: fmt.Sprintf("%d", r.Spec.Replicas),
+		},
+		{
+			Name:  "Phase",
+			Value: string(r.Status.Phase),
+		},
+	}
+
+	text, err := c.templates.Render(notifier.TemplateData{
+		Canary:   r,
+		Event:    event,
+		Message:  message,
+		Fields:   fields,
+		Severity: severity,
+	})
+	if err != nil {
+		c.logger.With("canary", fmt.Sprintf("%s.%s", r.Name, r.Namespace)).
+			Errorf("Notifier %v", err)
+		text = message
+	}
+
+	// the notifier of the canary is resolved now, the canary may be gone once the message is posted
+	if err := notifier.PostTo(c.notifier, c.notifierFor(r), r.Name, r.Namespace, text, fields, severity); err != nil {
+		c.logger.With("canary", fmt.Sprintf("%s.%s", r.Name, r.Namespace)).
+			Errorf("Notifier %v", err)
+	}
 }
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const finalizerName = "example.app/finalizer"
+
+// cleanupHook releases a side effect of a canary, it runs before the finalizer is removed
+type cleanupHook func(cd *examplev1beta1.Canary) error
+
+// defaultCleanupHooks returns the hooks run for every deleted canary
+func (c *Controller) defaultCleanupHooks() []cleanupHook {
+	return []cleanupHook{
+		c.restoreTarget,
+		c.forgetCanary,
+	}
+}
+
+// finalize runs the cleanup hooks of a canary marked for deletion and removes
+// the finalizer once all of them succeeded, the deletion is notified once the
+// finalizer is gone so that retried cleanups do not notify it again
+func (c *Controller) finalize(cd *examplev1beta1.Canary) error {
+	if !hasFinalizer(cd) {
+		return nil
+	}
+
+	for _, hook := range c.cleanupHooks {
+		if err := hook(cd); err != nil {
+			c.recordEventErrorf(cd, ReasonCleanupFailed, "Cleanup of canary %s.%s failed: %v", cd.Name, cd.Namespace, err)
+			return err
+		}
+	}
+
+	if err := c.removeFinalizer(cd); err != nil {
+		return err
+	}
+	c.notifyDeleted(cd)
+	return nil
+}
+
+// removeFinalizer removes the finalizer of the controller from the canary, the API
+// server deletes a canary marked for deletion once its finalizers are gone
+func (c *Controller) removeFinalizer(cd *examplev1beta1.Canary) error {
+	return c.updateFinalizers(cd, func(finalizers []string) []string {
+		var result []string
+		for _, f := range finalizers {
+			if f != finalizerName {
+				result = append(result, f)
+			}
+		}
+		return result
+	})
+}
+
+// ensureFinalizer adds the finalizer so that deletions are seen by the controller
+// even when the delete event is missed during a restart
+func (c *Controller) ensureFinalizer(cd *examplev1beta1.Canary) error {
+	if hasFinalizer(cd) {
+		return nil
+	}
+	// the finalizer of the canaries of an unwatched namespace is not added back
+	if _, ok := c.informersFor(cd.Namespace); !ok {
+		return nil
+	}
+	return c.updateFinalizers(cd, func(finalizers []string) []string {
+		return append(finalizers, finalizerName)
+	})
+}
+
+func (c *Controller) updateFinalizers(cd *examplev1beta1.Canary, mutate func([]string) []string) error {
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		latest, err := c.exampleClient.ExampleV1beta1().Canaries(cd.Namespace).Get(context.TODO(), cd.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		cdCopy := latest.DeepCopy()
+		cdCopy.Finalizers = mutate(cdCopy.Finalizers)
+		_, err = c.exampleClient.ExampleV1beta1().Canaries(cd.Namespace).Update(context.TODO(), cdCopy, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("updating finalizers of canary %s.%s failed: %w", cd.Name, cd.Namespace, err)
+	}
+	return nil
+}
+
+func hasFinalizer(cd *examplev1beta1.Canary) bool {
+	for _, f := range cd.Finalizers {
+		if f == finalizerName {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Controller) forgetCanary(cd *examplev1beta1.Canary) error {
+	c.canaries.Delete(fmt.Sprintf("%s.%s", cd.Name, cd.Namespace))
+	c.notifiers.Delete(fmt.Sprintf("%s.%s", cd.Name, cd.Namespace))
+	c.recorder.DeleteCanary(cd.Name, cd.Namespace)
+	c.forgetHooks(cd)
+	return nil
+}
+
+func (c *Controller) notifyDeleted(cd *examplev1beta1.Canary) {
+	c.recordEventInfof(cd, ReasonDeleted, "Canary %s.%s deleted", cd.Name, cd.Namespace)
+	c.alert(cd, notifier.EventDeleted, fmt.Sprintf("Canary %s.%s deleted", cd.Name, cd.Namespace), notifier.SeverityInfo)
+}
+
+// restoreTarget scales the target of an unfinished rollout back to the stable
+// image, the canary Deployment is garbage collected with the canary
+func (c *Controller) restoreTarget(cd *examplev1beta1.Canary) error {
+	inf, ok := c.informersFor(cd.Namespace)
+	if !ok {
+		return nil
+	}
+	return c.restoreTargetOf(cd, inf)
+}
+
+// restoreTargetOf restores the target read from the informers of its namespace
+func (c *Controller) restoreTargetOf(cd *examplev1beta1.Canary, inf Informers) error {
+	if cd.Spec.TargetRef == nil || cd.Status.StableImage == "" {
+		return nil
+	}
+	target, err := inf.DeploymentInformer.Lister().Deployments(cd.Namespace).Get(cd.Spec.TargetRef.Name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(target.Spec.Template.Spec.Containers) == 0 {
+		return nil
+	}
+	_, err = c.scaleTarget(target, cd.Spec.Replicas, cd.Status.StableImage)
+	return err
+}
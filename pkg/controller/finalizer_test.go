@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"errors"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func deleting(cd *examplev1beta1.Canary) *examplev1beta1.Canary {
+	now := metav1.Now()
+	cd.DeletionTimestamp = &now
+	cd.Finalizers = []string{finalizerName}
+	return cd
+}
+
+func TestSyncHandler_Finalize(t *testing.T) {
+	cd := deleting(inProgress(newRolloutCanary("podinfo:2.0", 50), 1, 50))
+	f := newFixture(t, cd, newTarget("podinfo:2.0", 2), newCanaryTarget(cd, 2))
+	f.ctrl.canaries.Store("podinfo.test", cd)
+
+	f.sync()
+
+	if got := f.canary(); hasFinalizer(got) {
+		t.Errorf("expected the finalizer to be removed, got %v", got.Finalizers)
+	}
+	if target := f.deployment("podinfo"); image(target) != "podinfo:1.0" || *target.Spec.Replicas != 4 {
+		t.Errorf("expected the target to be restored, got %d of %s", *target.Spec.Replicas, image(target))
+	}
+	if _, ok := f.ctrl.canaries.Load("podinfo.test"); ok {
+		t.Error("expected the canary to be forgotten")
+	}
+	if !hasEvent(f.recordedEvents(), "Normal Deleted") {
+		t.Error("expected a Deleted event")
+	}
+	if messages := f.notifier.posted(); len(messages) != 1 || messages[0] != "info: Canary podinfo.test deleted" {
+		t.Errorf("expected the deletion to be notified, got %v", messages)
+	}
+}
+
+func TestSyncHandler_FinalizeFailure(t *testing.T) {
+	f := newFixture(t, deleting(newCanary("podinfo:2.0")))
+	f.ctrl.cleanupHooks = []cleanupHook{func(cd *examplev1beta1.Canary) error {
+		return errors.New("cleanup failed")
+	}}
+
+	// the finalizer is kept so that the cleanup is retried
+	if err := f.ctrl.syncHandler(testKey); err == nil {
+		t.Error("expected the cleanup error to be returned")
+	}
+	if got := f.canary(); !hasFinalizer(got) {
+		t.Error("expected the finalizer to be kept")
+	}
+	if !hasEvent(f.recordedEvents(), "Warning CleanupFailed") {
+		t.Error("expected a CleanupFailed event")
+	}
+}
+
+func TestSyncHandler_DeletedWithoutFinalizer(t *testing.T) {
+	cd := deleting(newCanary("podinfo:2.0"))
+	cd.Finalizers = nil
+	f := newFixture(t, cd)
+
+	f.sync()
+
+	if events := f.recordedEvents(); len(events) != 0 {
+		t.Errorf("expected the canary to be ignored, got %v", events)
+	}
+}
+
+func TestSyncHandler_FinalizeNotifiesOnce(t *testing.T) {
+	f := newFixture(t, deleting(newCanary("podinfo:2.0")))
+	failures := 2
+	f.ctrl.cleanupHooks = []cleanupHook{func(cd *examplev1beta1.Canary) error {
+		if failures > 0 {
+			failures--
+			return errors.New("cleanup failed")
+		}
+		return nil
+	}}
+
+	// the retried cleanups do not notify the deletion
+	for i := 0; i < 3; i++ {
+		f.ctrl.syncHandler(testKey)
+	}
+	if got := f.canary(); hasFinalizer(got) {
+		t.Fatalf("expected the finalizer to be removed, got %v", got.Finalizers)
+	}
+	deleted := 0
+	for _, message := range f.notifier.posted() {
+		if message == "info: Canary podinfo.test deleted" {
+			deleted++
+		}
+	}
+	if deleted != 1 {
+		t.Errorf("expected the deletion to be notified once, got %v", f.notifier.posted())
+	}
+}
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// Reasons of the Paused condition
+const (
+	ReasonSuspended = "Suspended"
+	ReasonHeld      = "Held"
+	ReasonResumed   = "Resumed"
+)
+
+// ReasonGateIgnored is used when the gate annotation is set without a rollout in progress
+const ReasonGateIgnored = "GateIgnored"
+
+func isSuspended(cd *examplev1beta1.Canary) bool {
+	return cd.Spec.Suspend != nil && *cd.Spec.Suspend
+}
+
+// pause sets the Paused condition and records an event when the canary is
+// paused or paused for another reason
+func (c *Controller) pause(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, reason, message string) {
+	if !meta.IsStatusConditionTrue(status.Conditions, examplev1beta1.CanaryConditionPaused) ||
+		meta.FindStatusCondition(status.Conditions, examplev1beta1.CanaryConditionPaused).Reason != reason {
+		c.recordEventInfof(cd, reason, "Canary %s.%s paused, %s", cd.Name, cd.Namespace, message)
+	}
+	setStatusCondition(cd, status, examplev1beta1.CanaryConditionPaused, metav1.ConditionTrue, reason, message)
+}
+
+// resume clears the Paused condition when the canary was paused for the reason
+func (c *Controller) resume(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, reason string) {
+	cond := meta.FindStatusCondition(status.Conditions, examplev1beta1.CanaryConditionPaused)
+	if cond == nil || cond.Status != metav1.ConditionTrue || cond.Reason != reason {
+		return
+	}
+	c.recordEventInfof(cd, ReasonResumed, "Canary %s.%s resumed", cd.Name, cd.Namespace)
+	setStatusCondition(cd, status, examplev1beta1.CanaryConditionPaused, metav1.ConditionFalse, ReasonResumed, "Canary is reconciled")
+}
+
+// ignoreGate removes an approve or abort gate set while no rollout is in progress
+// so that it does not act on the next rollout, a hold gate holds the next rollout
+func (c *Controller) ignoreGate(cd *examplev1beta1.Canary) error {
+	gate := cd.Annotations[examplev1beta1.CanaryGateAnnotation]
+	if gate != examplev1beta1.CanaryGateApprove && gate != examplev1beta1.CanaryGateAbort {
+		return nil
+	}
+	if err := c.clearGate(cd); err != nil {
+		return err
+	}
+	c.recordEventWarningf(cd, ReasonGateIgnored, "Gate %s of canary %s.%s ignored, no rollout is in progress", gate, cd.Name, cd.Namespace)
+	return nil
+}
+
+// clearGate removes the gate annotation once its action has been taken,
+// it is removed first so that a failed action is not repeated on a later rollout
+func (c *Controller) clearGate(cd *examplev1beta1.Canary) error {
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		latest, err := c.exampleClient.ExampleV1beta1().Canaries(cd.Namespace).Get(context.TODO(), cd.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if _, ok := latest.Annotations[examplev1beta1.CanaryGateAnnotation]; !ok {
+			return nil
+		}
+		cdCopy := latest.DeepCopy()
+		delete(cdCopy.Annotations, examplev1beta1.CanaryGateAnnotation)
+		_, err = c.exampleClient.ExampleV1beta1().Canaries(cd.Namespace).Update(context.TODO(), cdCopy, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("removing the gate of canary %s.%s failed: %w", cd.Name, cd.Namespace, err)
+	}
+	return nil
+}
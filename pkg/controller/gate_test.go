@@ -0,0 +1,123 @@
+package controller
+
+import (
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"testing"
+)
+
+func TestSyncHandler_Gates(t *testing.T) {
+	suspend := true
+
+	tests := []struct {
+		name    string
+		gate    string
+		suspend *bool
+		check   func(t *testing.T, f *fixture, cd *examplev1beta1.Canary, events []string)
+	}{
+		{
+			name: "hold",
+			gate: examplev1beta1.CanaryGateHold,
+			check: func(t *testing.T, f *fixture, cd *examplev1beta1.Canary, events []string) {
+				if cond := meta.FindStatusCondition(cd.Status.Conditions, examplev1beta1.CanaryConditionPaused); cond == nil || cond.Reason != ReasonHeld {
+					t.Errorf("expected the canary to be held, got %+v", cond)
+				}
+				if cd.Annotations[examplev1beta1.CanaryGateAnnotation] != examplev1beta1.CanaryGateHold {
+					t.Error("expected the hold gate to be kept")
+				}
+				if target := f.deployment("podinfo"); image(target) != "podinfo:1.0" || *target.Spec.Replicas != 2 {
+					t.Errorf("expected the target to be left as it is, got %d of %s", *target.Spec.Replicas, image(target))
+				}
+			},
+		},
+		{
+			name: "approve",
+			gate: examplev1beta1.CanaryGateApprove,
+			check: func(t *testing.T, f *fixture, cd *examplev1beta1.Canary, events []string) {
+				if _, ok := cd.Annotations[examplev1beta1.CanaryGateAnnotation]; ok {
+					t.Error("expected the gate to be removed")
+				}
+				if !hasEvent(events, "Normal Approved") {
+					t.Errorf("expected an Approved event, got %v", events)
+				}
+				if target := f.deployment("podinfo"); image(target) != "podinfo:2.0" || *target.Spec.Replicas != 4 {
+					t.Errorf("expected the target to be promoted, got %d of %s", *target.Spec.Replicas, image(target))
+				}
+				if cd.Status.StableImage != "podinfo:2.0" {
+					t.Errorf("expected podinfo:2.0 to be the stable image, got %s", cd.Status.StableImage)
+				}
+			},
+		},
+		{
+			name: "abort",
+			gate: examplev1beta1.CanaryGateAbort,
+			check: func(t *testing.T, f *fixture, cd *examplev1beta1.Canary, events []string) {
+				if _, ok := cd.Annotations[examplev1beta1.CanaryGateAnnotation]; ok {
+					t.Error("expected the gate to be removed")
+				}
+				if readyCondition(cd).Reason != ReasonRolledBack {
+					t.Errorf("expected the canary to be rolled back, got %+v", readyCondition(cd))
+				}
+				if target := f.deployment("podinfo"); image(target) != "podinfo:1.0" || *target.Spec.Replicas != 4 {
+					t.Errorf("expected the target to be restored, got %d of %s", *target.Spec.Replicas, image(target))
+				}
+				if f.deployment("podinfo-canary") != nil {
+					t.Error("expected the canary Deployment to be deleted")
+				}
+			},
+		},
+		{
+			name:    "suspend",
+			suspend: &suspend,
+			check: func(t *testing.T, f *fixture, cd *examplev1beta1.Canary, events []string) {
+				if cond := meta.FindStatusCondition(cd.Status.Conditions, examplev1beta1.CanaryConditionPaused); cond == nil || cond.Reason != ReasonSuspended {
+					t.Errorf("expected the canary to be suspended, got %+v", cond)
+				}
+				if cd.Status.CurrentStep != 0 || cd.Status.CurrentWeight != 50 {
+					t.Errorf("expected the rollout progress to be kept, got %+v", cd.Status)
+				}
+				if canary := f.deployment("podinfo-canary"); canary == nil || *canary.Spec.Replicas != 2 {
+					t.Errorf("expected the canary Deployment to be left as it is, got %+v", canary)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cd := inProgress(newRolloutCanary("podinfo:2.0", 50, 100), 0, 50)
+			if tt.gate != "" {
+				cd.Annotations = map[string]string{examplev1beta1.CanaryGateAnnotation: tt.gate}
+			}
+			cd.Spec.Suspend = tt.suspend
+			f := newFixture(t, cd, newTarget("podinfo:1.0", 2), newCanaryTarget(cd, 2))
+
+			f.sync()
+			tt.check(t, f, f.canary(), f.recordedEvents())
+		})
+	}
+}
+
+func TestSyncHandler_Resume(t *testing.T) {
+	cd := inProgress(newRolloutCanary("podinfo:2.0", 50, 100), 0, 50)
+	cd.Annotations = map[string]string{examplev1beta1.CanaryGateAnnotation: examplev1beta1.CanaryGateHold}
+	f := newFixture(t, cd, newTarget("podinfo:1.0", 2), newCanaryTarget(cd, 2))
+	f.sync()
+
+	// removing the hold gate resumes the rollout at its step
+	f.updateCanary(func(cd *examplev1beta1.Canary) {
+		delete(cd.Annotations, examplev1beta1.CanaryGateAnnotation)
+	})
+	f.sync()
+
+	got := f.canary()
+	if meta.IsStatusConditionTrue(got.Status.Conditions, examplev1beta1.CanaryConditionPaused) {
+		t.Error("expected the canary to be resumed")
+	}
+	if !hasEvent(f.recordedEvents(), "Normal Resumed") {
+		t.Error("expected a Resumed event")
+	}
+	if got.Status.CurrentStep != 1 {
+		t.Errorf("expected the rollout to advance, got step %d", got.Status.CurrentStep)
+	}
+}
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errHooksPending is returned while the webhooks of a gate are called in the background
+var errHooksPending = errors.New("waiting for the webhooks to answer")
+
+// isHooksPending returns true when the error reports webhooks that have not answered yet
+func isHooksPending(err error) bool {
+	return errors.Is(err, errHooksPending)
+}
+
+// hookCall is the result of a call of the webhooks of a type
+type hookCall struct {
+	done     bool
+	read     bool
+	err      error
+	finished time.Time
+}
+
+// hookCalls tracks the webhook calls made in the background so that slow webhooks
+// do not stall the reconcile workers, the zero value is ready to use
+type hookCalls struct {
+	mu       sync.Mutex
+	calls    map[string]*hookCall
+	inflight sync.WaitGroup
+}
+
+// callHooks posts the payload to the webhooks of the type in the order of the spec,
+// an error is returned for the first webhook that does not return 2xx. The webhooks
+// are called in the background, errHooksPending is returned until they all answered
+// and their result re-queues the canary. The result is returned at least once and
+// reused until the analysis interval elapsed so that the webhooks are not called on
+// every reconcile.
+func (c *Controller) callHooks(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, hookType examplev1beta1.CanaryWebhookType) error {
+	hooks := hooksOf(cd, hookType)
+	if len(hooks) == 0 {
+		return nil
+	}
+	key := hookCallKey(cd, hookType)
+
+	c.hookCalls.mu.Lock()
+	defer c.hookCalls.mu.Unlock()
+	if c.hookCalls.calls == nil {
+		c.hookCalls.calls = map[string]*hookCall{}
+	}
+	if call, ok := c.hookCalls.calls[key]; ok {
+		if !call.done {
+			return errHooksPending
+		}
+		if !call.read || time.Since(call.finished) < analysisInterval(cd) {
+			call.read = true
+			return call.err
+		}
+	}
+
+	call := &hookCall{}
+	c.hookCalls.calls[key] = call
+	payloads := make([]examplev1beta1.CanaryWebhookPayload, len(hooks))
+	for i, hook := range hooks {
+		payloads[i] = newHookPayload(cd, status, hook)
+	}
+
+	c.hookCalls.inflight.Add(1)
+	go func() {
+		defer c.hookCalls.inflight.Done()
+		var err error
+		for i, hook := range hooks {
+			if err = callWebhook(hook.URL, payloads[i], hookTimeout(hook), 0); err != nil {
+				err = fmt.Errorf("%s webhook %s: %w", hookType, hook.Name, err)
+				break
+			}
+		}
+
+		c.hookCalls.mu.Lock()
+		// the calls of a forgotten canary or a finished rollout are dropped
+		if c.hookCalls.calls[key] == call {
+			call.done = true
+			call.err = err
+			call.finished = time.Now()
+		}
+		c.hookCalls.mu.Unlock()
+		c.enqueueAfter(cd, 0)
+	}()
+	return errHooksPending
+}
+
+// rollbackRequested returns an error when a rollback webhook does not return 2xx,
+// unlike the other gates a failed rollback webhook rolls the rollout back at once
+func (c *Controller) rollbackRequested(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus) error {
+	if err := c.callHooks(cd, status, examplev1beta1.CanaryWebhookRollback); err != nil && !isHooksPending(err) {
+		return err
+	}
+	return nil
+}
+
+// forgetHooks drops the webhook results of the canary so that the next rollout calls them again
+func (c *Controller) forgetHooks(cd *examplev1beta1.Canary) {
+	prefix := fmt.Sprintf("%s.%s/", cd.Name, cd.Namespace)
+	c.hookCalls.mu.Lock()
+	defer c.hookCalls.mu.Unlock()
+	for key := range c.hookCalls.calls {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.hookCalls.calls, key)
+		}
+	}
+}
+
+// callPostRolloutHooks posts to the post-rollout webhooks in the background, their
+// result does not change the outcome of the rollout
+func (c *Controller) callPostRolloutHooks(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus) {
+	for _, hook := range hooksOf(cd, examplev1beta1.CanaryWebhookPostRollout) {
+		hook := hook
+		payload := newHookPayload(cd, status, hook)
+		go func() {
+			if err := callWebhook(hook.URL, payload, hookTimeout(hook), eventWebhookRetries); err != nil {
+				c.logger.With("canary", fmt.Sprintf("%s.%s", cd.Name, cd.Namespace)).
+					Errorf("post-rollout webhook %s failed: %v", hook.Name, err)
+			}
+		}()
+	}
+}
+
+func newHookPayload(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, hook examplev1beta1.CanaryWebhook) examplev1beta1.CanaryWebhookPayload {
+	return examplev1beta1.CanaryWebhookPayload{
+		APIVersion: examplev1beta1.CanaryEventPayloadVersion,
+		Kind:       "CanaryWebhook",
+		Name:       cd.Name,
+		Namespace:  cd.Namespace,
+		Phase:      status.Phase,
+		Type:       hook.Type,
+		Image:      cd.Spec.Image,
+		Weight:     status.CurrentWeight,
+		Metadata:   hook.Metadata,
+		Timestamp:  metav1.Now(),
+	}
+}
+
+// hookCallKey identifies the calls of the webhooks of a type for the image rolled out
+func hookCallKey(cd *examplev1beta1.Canary, hookType examplev1beta1.CanaryWebhookType) string {
+	return fmt.Sprintf("%s.%s/%s/%s", cd.Name, cd.Namespace, hookType, cd.Spec.Image)
+}
+
+func hooksOf(cd *examplev1beta1.Canary, hookType examplev1beta1.CanaryWebhookType) []examplev1beta1.CanaryWebhook {
+	var hooks []examplev1beta1.CanaryWebhook
+	for _, hook := range cd.Spec.Webhooks {
+		if hook.Type == hookType {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+func hookTimeout(hook examplev1beta1.CanaryWebhook) time.Duration {
+	if hook.Timeout == nil {
+		return examplev1beta1.DefaultWebhookTimeout
+	}
+	return hook.Timeout.Duration
+}
@@ -0,0 +1,287 @@
+package controller
+
+import (
+	"encoding/json"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookServer answers the webhooks with the status of their type and records the payloads
+type hookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   map[examplev1beta1.CanaryWebhookType]int
+	payloads []examplev1beta1.CanaryWebhookPayload
+}
+
+func newHookServer(t *testing.T) *hookServer {
+	s := &hookServer{status: map[examplev1beta1.CanaryWebhookType]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		payload := examplev1beta1.CanaryWebhookPayload{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("invalid webhook payload %s: %v", body, err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.payloads = append(s.payloads, payload)
+		if status, ok := s.status[payload.Type]; ok {
+			w.WriteHeader(status)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *hookServer) answer(hookType examplev1beta1.CanaryWebhookType, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status[hookType] = status
+}
+
+// calls returns the payloads received for the type
+func (s *hookServer) calls(hookType examplev1beta1.CanaryWebhookType) []examplev1beta1.CanaryWebhookPayload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var payloads []examplev1beta1.CanaryWebhookPayload
+	for _, p := range s.payloads {
+		if p.Type == hookType {
+			payloads = append(payloads, p)
+		}
+	}
+	return payloads
+}
+
+// withHook adds a webhook of the type pointing to the server
+func withHook(cd *examplev1beta1.Canary, s *hookServer, hookType examplev1beta1.CanaryWebhookType) *examplev1beta1.Canary {
+	cd.Spec.Webhooks = append(cd.Spec.Webhooks, examplev1beta1.CanaryWebhook{
+		Name:     string(hookType),
+		Type:     hookType,
+		URL:      s.URL + "/" + string(hookType),
+		Metadata: map[string]string{"suite": "smoke"},
+	})
+	return cd
+}
+
+// syncHooks runs a reconcile calling the webhooks and the reconcile re-queued by their answer
+func (f *fixture) syncHooks() {
+	f.t.Helper()
+	f.sync()
+	f.ctrl.hookCalls.inflight.Wait()
+	f.sync()
+}
+
+func TestSyncHandler_PreRolloutHooks(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		canary   bool
+		failures int32
+	}{
+		{"advance", http.StatusOK, true, 0},
+		{"halt", http.StatusServiceUnavailable, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newHookServer(t)
+			s.answer(examplev1beta1.CanaryWebhookPreRollout, tt.status)
+			cd := withHook(newRolloutCanary("podinfo:2.0", 50), s, examplev1beta1.CanaryWebhookPreRollout)
+			f := newFixture(t, cd, newTarget("podinfo:1.0", 4))
+
+			// the worker does not wait for the webhook
+			f.sync()
+			if f.deployment("podinfo-canary") != nil {
+				t.Fatal("expected the canary Deployment to wait for the pre-rollout webhook")
+			}
+			if got := readyCondition(f.canary()); got.Reason != "WaitingForPreRollout" {
+				t.Errorf("expected the rollout to wait for the pre-rollout webhook, got %+v", got)
+			}
+
+			f.ctrl.hookCalls.inflight.Wait()
+			f.sync()
+			if exists := f.deployment("podinfo-canary") != nil; exists != tt.canary {
+				t.Errorf("expected the canary Deployment to exist: %t, got %t", tt.canary, exists)
+			}
+			if got := f.canary(); got.Status.FailedChecks != tt.failures {
+				t.Errorf("expected %d failed checks, got %d", tt.failures, got.Status.FailedChecks)
+			}
+			calls := s.calls(examplev1beta1.CanaryWebhookPreRollout)
+			if len(calls) != 1 {
+				t.Fatalf("expected a single pre-rollout call, got %d", len(calls))
+			}
+			if p := calls[0]; p.Name != "podinfo" || p.Namespace != "test" || p.Image != "podinfo:2.0" || p.Metadata["suite"] != "smoke" {
+				t.Errorf("unexpected payload %+v", p)
+			}
+		})
+	}
+}
+
+func TestSyncHandler_PreRolloutHooksRollback(t *testing.T) {
+	s := newHookServer(t)
+	s.answer(examplev1beta1.CanaryWebhookPreRollout, http.StatusInternalServerError)
+	cd := withHook(newRolloutCanary("podinfo:2.0", 50), s, examplev1beta1.CanaryWebhookPreRollout)
+	f := newFixture(t, cd, newTarget("podinfo:1.0", 4))
+
+	// the failures count as failed checks until the threshold rolls the canary back
+	f.syncHooks()
+	f.syncHooks()
+	got := f.canary()
+	if readyCondition(got).Reason != ReasonRolledBack {
+		t.Fatalf("expected the canary to be rolled back, got %+v", readyCondition(got))
+	}
+	if calls := s.calls(examplev1beta1.CanaryWebhookPreRollout); len(calls) != 2 {
+		t.Errorf("expected 2 pre-rollout calls, got %d", len(calls))
+	}
+}
+
+func TestSyncHandler_RolloutHooks(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		step     int32
+		failures int32
+	}{
+		{"advance", http.StatusOK, 1, 0},
+		{"halt", http.StatusBadRequest, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newHookServer(t)
+			s.answer(examplev1beta1.CanaryWebhookRollout, tt.status)
+			cd := withHook(inProgress(newRolloutCanary("podinfo:2.0", 50, 100), 0, 50), s, examplev1beta1.CanaryWebhookRollout)
+			f := newFixture(t, cd, newTarget("podinfo:1.0", 2), newCanaryTarget(cd, 2))
+
+			// the step is not promoted before the webhook answered
+			f.sync()
+			if got := f.canary(); got.Status.CurrentStep != 0 || got.Status.FailedChecks != 0 {
+				t.Fatalf("expected the step to wait for the rollout webhook, got %+v", got.Status)
+			}
+
+			f.ctrl.hookCalls.inflight.Wait()
+			f.sync()
+			got := f.canary()
+			if got.Status.CurrentStep != tt.step || got.Status.FailedChecks != tt.failures {
+				t.Errorf("expected step %d with %d failed checks, got %+v", tt.step, tt.failures, got.Status)
+			}
+			calls := s.calls(examplev1beta1.CanaryWebhookRollout)
+			if len(calls) != 1 || calls[0].Weight != 50 {
+				t.Errorf("expected a rollout call at 50%%, got %+v", calls)
+			}
+		})
+	}
+}
+
+func TestSyncHandler_ConfirmPromotionHooks(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		promoted bool
+	}{
+		{"advance", http.StatusOK, true},
+		{"halt", http.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newHookServer(t)
+			s.answer(examplev1beta1.CanaryWebhookConfirmPromotion, tt.status)
+			cd := withHook(inProgress(newRolloutCanary("podinfo:2.0", 50), 1, 50), s, examplev1beta1.CanaryWebhookConfirmPromotion)
+			cd.Spec.Analysis.Interval.Duration = time.Hour
+			f := newFixture(t, cd, newTarget("podinfo:1.0", 2), newCanaryTarget(cd, 2))
+
+			f.syncHooks()
+			if promoted := image(f.deployment("podinfo")) == "podinfo:2.0"; promoted != tt.promoted {
+				t.Fatalf("expected the target to be promoted: %t, got %t", tt.promoted, promoted)
+			}
+			if !tt.promoted && readyCondition(f.canary()).Reason != "WaitingForConfirmation" {
+				t.Errorf("expected the promotion to wait, got %+v", readyCondition(f.canary()))
+			}
+
+			// the refusal is kept for the analysis interval
+			f.sync()
+			f.sync()
+			if calls := s.calls(examplev1beta1.CanaryWebhookConfirmPromotion); len(calls) != 1 {
+				t.Errorf("expected a single confirm-promotion call per analysis interval, got %d", len(calls))
+			}
+		})
+	}
+}
+
+func TestSyncHandler_RollbackHooks(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		rolledBack bool
+	}{
+		{"advance", http.StatusOK, false},
+		{"rollback", http.StatusServiceUnavailable, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newHookServer(t)
+			s.answer(examplev1beta1.CanaryWebhookRollback, tt.status)
+			cd := withHook(inProgress(newRolloutCanary("podinfo:2.0", 50, 100), 0, 50), s, examplev1beta1.CanaryWebhookRollback)
+			f := newFixture(t, cd, newTarget("podinfo:1.0", 2), newCanaryTarget(cd, 2))
+
+			// a pending rollback webhook does not hold the rollout
+			f.sync()
+			if got := f.canary(); got.Status.CurrentStep != 1 {
+				t.Fatalf("expected the rollout to advance, got %+v", got.Status)
+			}
+
+			f.ctrl.hookCalls.inflight.Wait()
+			f.sync()
+			got := f.canary()
+			if rolledBack := readyCondition(got).Reason == ReasonRolledBack; rolledBack != tt.rolledBack {
+				t.Errorf("expected the canary to be rolled back: %t, got %+v", tt.rolledBack, readyCondition(got))
+			}
+			if tt.rolledBack && f.deployment("podinfo-canary") != nil {
+				t.Error("expected the canary Deployment to be deleted")
+			}
+		})
+	}
+}
+
+func TestSyncHandler_PostRolloutHooks(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"succeeded", http.StatusOK},
+		// the answer of the post-rollout webhooks does not change the outcome
+		{"ignored failure", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newHookServer(t)
+			s.answer(examplev1beta1.CanaryWebhookPostRollout, tt.status)
+			cd := withHook(inProgress(newRolloutCanary("podinfo:2.0", 50), 1, 0), s, examplev1beta1.CanaryWebhookPostRollout)
+			cd.Status.StableImage = "podinfo:2.0"
+			f := newFixture(t, cd, newTarget("podinfo:2.0", 4))
+
+			f.sync()
+			if got := f.canary(); got.Status.Phase != examplev1beta1.CanaryPhaseSucceeded {
+				t.Fatalf("expected the rollout to succeed, got %s", got.Status.Phase)
+			}
+
+			// the post-rollout webhooks are called in the background
+			deadline := time.Now().Add(5 * time.Second)
+			for len(s.calls(examplev1beta1.CanaryWebhookPostRollout)) == 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			calls := s.calls(examplev1beta1.CanaryWebhookPostRollout)
+			if len(calls) != 1 || calls[0].Phase != examplev1beta1.CanaryPhaseSucceeded {
+				t.Errorf("expected a post-rollout call of the succeeded rollout, got %+v", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"time"
+)
+
+// InformerFactory creates the informers of a namespace, the informers are
+// started by the controller and metav1.NamespaceAll stands for every namespace
+type InformerFactory func(namespace string) Informers
+
+// WatchOptions select the namespaces where the canaries are reconciled,
+// every namespace is watched when both options are empty
+type WatchOptions struct {
+	// Namespaces are watched for the whole life of the controller
+	Namespaces []string
+	// Selector adds the namespaces matching the labels, the informers of a
+	// namespace are stopped when it is deleted or no longer matches
+	Selector labels.Selector
+}
+
+type namespaceInformers struct {
+	Informers
+	done   chan struct{}
+	synced []cache.InformerSynced
+}
+
+// hasSynced reports whether the caches of the namespace are filled
+func (inf *namespaceInformers) hasSynced() bool {
+	for _, synced := range inf.synced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// informersFor returns the informers watching the namespace
+func (c *Controller) informersFor(namespace string) (Informers, bool) {
+	c.informersMu.RLock()
+	defer c.informersMu.RUnlock()
+	if inf, ok := c.informers[metav1.NamespaceAll]; ok {
+		return inf.Informers, true
+	}
+	inf, ok := c.informers[namespace]
+	if !ok {
+		return Informers{}, false
+	}
+	return inf.Informers, true
+}
+
+// informersSynced reports whether the informers watching the namespace are synced,
+// the namespaces added by the selector are synced in the background
+func (c *Controller) informersSynced(namespace string) bool {
+	c.informersMu.RLock()
+	defer c.informersMu.RUnlock()
+	inf, ok := c.informers[metav1.NamespaceAll]
+	if !ok {
+		inf, ok = c.informers[namespace]
+	}
+	return ok && inf.hasSynced()
+}
+
+// startNamespaces starts the informers of the static namespaces and the watch of
+// the selected namespaces, it returns once their caches are synced
+func (c *Controller) startNamespaces(stopCh <-chan struct{}) error {
+	namespaces := c.watch.Namespaces
+	if len(namespaces) == 0 && c.watch.Selector == nil {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var synced []cache.InformerSynced
+	for _, ns := range namespaces {
+		synced = append(synced, c.watchNamespace(ns, stopCh)...)
+	}
+
+	if c.watch.Selector != nil {
+		c.logger.Infof("Watching namespaces matching %s", c.watch.Selector)
+		factory := kubeinformers.NewSharedInformerFactoryWithOptions(c.kubeClient, 30*time.Second,
+			kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.LabelSelector = c.watch.Selector.String()
+			}))
+		namespaceInformer := factory.Core().V1().Namespaces().Informer()
+		namespaceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				// the canaries of the namespace are re-queued until its informers are synced
+				if ns, ok := obj.(*corev1.Namespace); ok {
+					c.watchNamespace(ns.Name, stopCh)
+				}
+			},
+			// a namespace losing its labels is delivered as a delete by the filtered watch
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if ns, ok := obj.(*corev1.Namespace); ok && !c.isStaticNamespace(ns.Name) {
+					c.unwatchNamespace(ns.Name)
+				}
+			},
+		})
+		go namespaceInformer.Run(stopCh)
+		synced = append(synced, namespaceInformer.HasSynced)
+	}
+
+	if ok := cache.WaitForNamedCacheSync(controllerAgentName, stopCh, synced...); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	return nil
+}
+
+// watchNamespace starts the informers of a namespace unless it is already watched
+func (c *Controller) watchNamespace(namespace string, stopCh <-chan struct{}) []cache.InformerSynced {
+	c.informersMu.Lock()
+	defer c.informersMu.Unlock()
+	if _, ok := c.informers[namespace]; ok {
+		return nil
+	}
+
+	if namespace == metav1.NamespaceAll {
+		c.logger.Info("Watching all namespaces")
+	} else {
+		c.logger.Infof("Watching namespace %s", namespace)
+	}
+	inf := &namespaceInformers{
+		Informers: c.newInformers(namespace),
+		done:      make(chan struct{}),
+	}
+	c.informers[namespace] = inf
+	c.addEventHandlers(inf.Informers)
+
+	// the informers stop with the controller or when the namespace is unwatched
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-stopCh:
+		case <-inf.done:
+		}
+		close(stop)
+	}()
+
+	informers := []cache.SharedIndexInformer{
+		inf.CanaryInformer.Informer(),
+		inf.DeploymentInformer.Informer(),
+		inf.SecretInformer.Informer(),
+	}
+	for _, informer := range informers {
+		go informer.Run(stop)
+		inf.synced = append(inf.synced, informer.HasSynced)
+	}
+	return inf.synced
+}
+
+// unwatchNamespace stops the informers of a namespace and forgets its canaries,
+// the canaries left behind are no longer reconciled and are queued to be released
+// so that the event handler does not wait for the API server
+func (c *Controller) unwatchNamespace(namespace string) {
+	c.informersMu.Lock()
+	inf, ok := c.informers[namespace]
+	delete(c.informers, namespace)
+	c.informersMu.Unlock()
+	if !ok {
+		return
+	}
+
+	canaries, err := inf.CanaryInformer.Lister().Canaries(namespace).List(labels.Everything())
+	if err != nil {
+		c.logger.Errorf("Listing the canaries of namespace %s failed: %v", namespace, err)
+	}
+	for _, cd := range canaries {
+		if !hasFinalizer(cd) {
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(cd)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		c.unwatched.Store(key, inf.Informers)
+		c.workqueue.Add(key)
+	}
+
+	c.logger.Infof("Stopped watching namespace %s", namespace)
+	close(inf.done)
+
+	c.canaries.Range(func(key, value interface{}) bool {
+		if cd, ok := value.(*examplev1beta1.Canary); ok && cd.Namespace == namespace {
+			c.canaries.Delete(key)
+			c.notifiers.Delete(key)
+			c.recorder.DeleteCanary(cd.Name, cd.Namespace)
+			c.forgetHooks(cd)
+		}
+		return true
+	})
+}
+
+// release removes the finalizer of a canary of an unwatched namespace so that deleting
+// it or the namespace does not wait for the controller, a rollout in progress is rolled
+// back first as nothing promotes it anymore. The stopped informers keep the last state
+// of the namespace.
+func (c *Controller) release(key string, inf Informers, namespace, name string) error {
+	cd, err := inf.CanaryInformer.Lister().Canaries(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		c.unwatched.Delete(key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if cd.Status.CanaryImage != "" && cd.Status.CanaryImage != cd.Status.StableImage {
+		if err := c.restoreTargetOf(cd, inf); err != nil {
+			return err
+		}
+		if err := c.deleteCanaryDeployment(cd); err != nil {
+			return err
+		}
+	}
+	if err := c.removeFinalizer(cd); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	c.unwatched.Delete(key)
+	c.logger.With("canary", fmt.Sprintf("%s.%s", cd.Name, cd.Namespace)).Info("Released the canary of an unwatched namespace")
+	return nil
+}
+
+func (c *Controller) isStaticNamespace(namespace string) bool {
+	for _, ns := range c.watch.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"testing"
+)
+
+func TestUnwatchNamespace_RemovesFinalizers(t *testing.T) {
+	cd := newCanary("podinfo:2.0")
+	cd.Finalizers = []string{finalizerName}
+	f := newFixture(t, cd)
+	f.ctrl.canaries.Store("podinfo.test", cd)
+	done := f.ctrl.informers["test"].done
+
+	f.ctrl.unwatchNamespace("test")
+
+	// the finalizer is removed by a worker, not by the event handler
+	if got := f.canary(); !hasFinalizer(got) {
+		t.Fatal("expected the finalizer to be kept until the canary is released")
+	}
+	if f.ctrl.workqueue.Len() != 1 {
+		t.Fatalf("expected the canary to be queued, got %d keys", f.ctrl.workqueue.Len())
+	}
+	if _, ok := f.ctrl.informersFor("test"); ok {
+		t.Error("expected the namespace to be unwatched")
+	}
+	select {
+	case <-done:
+	default:
+		t.Error("expected the informers to be stopped")
+	}
+	if _, ok := f.ctrl.canaries.Load("podinfo.test"); ok {
+		t.Error("expected the canary to be forgotten")
+	}
+
+	// deleting the canary or its namespace must not wait for the controller
+	f.sync()
+	if got := f.canary(); hasFinalizer(got) {
+		t.Errorf("expected the finalizer to be removed, got %v", got.Finalizers)
+	}
+	if _, ok := f.ctrl.unwatched.Load(testKey); ok {
+		t.Error("expected the canary to be released")
+	}
+
+	// a reconcile still in flight does not add the finalizer back
+	if err := f.ctrl.ensureFinalizer(f.canary()); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.canary(); hasFinalizer(got) {
+		t.Errorf("expected the finalizer not to be added back, got %v", got.Finalizers)
+	}
+}
+
+func TestUnwatchNamespace_RestoresRollout(t *testing.T) {
+	cd := inProgress(newRolloutCanary("podinfo:2.0", 50), 0, 50)
+	cd.Finalizers = []string{finalizerName}
+	f := newFixture(t, cd, newTarget("podinfo:1.0", 2), newCanaryTarget(cd, 2))
+
+	f.ctrl.unwatchNamespace("test")
+	f.sync()
+
+	// nothing would promote the rollout, the target is rolled back to the stable image
+	if target := f.deployment("podinfo"); image(target) != "podinfo:1.0" || *target.Spec.Replicas != 4 {
+		t.Errorf("expected the target to be restored, got %d of %s", *target.Spec.Replicas, image(target))
+	}
+	if f.deployment("podinfo-canary") != nil {
+		t.Error("expected the canary Deployment to be deleted")
+	}
+	if got := f.canary(); hasFinalizer(got) {
+		t.Errorf("expected the finalizer to be removed, got %v", got.Finalizers)
+	}
+}
+
+func TestUnwatchNamespace_WatchedAgain(t *testing.T) {
+	cd := newCanary("podinfo:2.0")
+	cd.Finalizers = []string{finalizerName}
+	f := newFixture(t, cd)
+	informers := f.ctrl.informers["test"]
+
+	f.ctrl.unwatchNamespace("test")
+	f.ctrl.informersMu.Lock()
+	f.ctrl.informers["test"] = &namespaceInformers{Informers: informers.Informers, synced: informers.synced}
+	f.ctrl.informersMu.Unlock()
+
+	// the canary is reconciled again instead of being released
+	f.sync()
+	if got := f.canary(); !hasFinalizer(got) {
+		t.Errorf("expected the finalizer to be kept, got %v", got.Finalizers)
+	}
+	if _, ok := f.ctrl.unwatched.Load(testKey); ok {
+		t.Error("expected the canary not to be released")
+	}
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// canaryNotifier is the notifier built for the notification spec of a canary
+type canaryNotifier struct {
+	spec     examplev1beta1.CanaryNotifications
+	notifier notifier.Interface
+}
+
+// getSecret reads a Secret referenced by a canary from the cache of its namespace
+func (c *Controller) getSecret(namespace, name string) (*corev1.Secret, error) {
+	inf, ok := c.informersFor(namespace)
+	if !ok {
+		return nil, fmt.Errorf("namespace %s is not watched", namespace)
+	}
+	return inf.SecretInformer.Lister().Secrets(namespace).Get(name)
+}
+
+// notifierFor returns the notifier configured in the canary spec, nil is
+// returned when the canary relies on the global notifier, the notifier is
+// cached until the spec changes or the canary is forgotten
+func (c *Controller) notifierFor(cd *examplev1beta1.Canary) notifier.Interface {
+	key := fmt.Sprintf("%s.%s", cd.Name, cd.Namespace)
+	spec := cd.Spec.Notifications
+	if spec == nil {
+		c.notifiers.Delete(key)
+		return nil
+	}
+
+	if value, ok := c.notifiers.Load(key); ok {
+		if cached := value.(*canaryNotifier); equality.Semantic.DeepEqual(cached.spec, *spec) {
+			return cached.notifier
+		}
+	}
+
+	// the hook URL is read from the cached Secret on every notification, the provider is
+	// rebuilt when the Secret is updated so that rotations apply to the next one
+	client := notifier.NewSecretNotifier(spec.Provider, *notifier.NewFactory("", spec.Username, spec.Channel, c.notifierClient),
+		notifier.SecretReference{
+			Namespace: cd.Namespace,
+			Name:      spec.SecretRef.Name,
+			Key:       spec.SecretRef.Key,
+		}, c.getSecret)
+
+	minSeverity := spec.MinSeverity
+	if minSeverity == "" {
+		minSeverity = notifier.SeverityInfo
+	}
+	n := notifier.NewComposite(notifier.Route{
+		Provider:    spec.Provider,
+		Notifier:    notifier.NewInstrumented(spec.Provider, client, c.recorder),
+		MinSeverity: minSeverity,
+	})
+
+	// the notifications of a deleted canary must not cache it again once it is forgotten
+	if cd.DeletionTimestamp == nil {
+		c.notifiers.Store(key, &canaryNotifier{spec: *spec.DeepCopy(), notifier: n})
+	}
+	return n
+}
@@ -0,0 +1,449 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"time"
+)
+
+// canaryLabel is added to the pods of the canary Deployment so that its selector
+// does not match the pods of the target
+const canaryLabel = "example.app/canary"
+
+// defaultSteps promote the target at once when the canary has no steps
+var defaultSteps = []int32{examplev1beta1.DefaultStepWeight}
+
+// syncRollout moves the replicas of the target Deployment step by step to a canary
+// Deployment running the new image and promotes the target after the last step,
+// the progress is kept in the status so that a restarted controller resumes it
+func (c *Controller) syncRollout(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, selector map[string]string) error {
+	target, err := c.getTarget(cd, selector)
+	if err != nil {
+		return c.failRollout(cd, status, err)
+	}
+
+	if status.StableImage == "" {
+		status.StableImage = target.Spec.Template.Spec.Containers[0].Image
+	}
+
+	// a rolled back rollout holds until the spec changes
+	if isRolledBack(cd, status) {
+		if err := c.ignoreGate(cd); err != nil {
+			return err
+		}
+		status.ObservedGeneration = cd.Generation
+		return c.syncStatus(cd, *status)
+	}
+
+	// a new image restarts the rollout from the first step
+	if status.CanaryImage != cd.Spec.Image {
+		status.CanaryImage = cd.Spec.Image
+		status.CurrentStep = 0
+		now := metav1.Now()
+		status.StepStartTime = &now
+		status.FailedChecks = 0
+		status.LastCheckTime = nil
+		status.MetricResults = nil
+		if status.CanaryImage != status.StableImage {
+			c.recordEventInfof(cd, ReasonRolloutStarted, "Rollout of %s to %s started", cd.Spec.TargetRef.Name, cd.Spec.Image)
+			c.alert(cd, notifier.EventStarted, fmt.Sprintf("Rollout of %s started", cd.Spec.Image), notifier.SeverityInfo)
+		}
+	}
+	status.ObservedGeneration = cd.Generation
+
+	if status.CanaryImage == status.StableImage {
+		if err := c.ignoreGate(cd); err != nil {
+			return err
+		}
+		return c.syncPromoted(cd, status, target)
+	}
+
+	steps := rolloutSteps(cd)
+	setStatusPhase(status, examplev1beta1.CanaryPhaseProgressing)
+
+	switch cd.Annotations[examplev1beta1.CanaryGateAnnotation] {
+	case examplev1beta1.CanaryGateHold:
+		c.pause(cd, status, ReasonHeld, fmt.Sprintf("rollout held at %d%% of the replicas by the %s annotation",
+			status.CurrentWeight, examplev1beta1.CanaryGateAnnotation))
+		return c.syncStatus(cd, *status)
+	case examplev1beta1.CanaryGateApprove:
+		if err := c.clearGate(cd); err != nil {
+			return err
+		}
+		c.resume(cd, status, ReasonHeld)
+		c.recordEventInfof(cd, ReasonApproved, "Promotion of %s to %s approved", cd.Spec.TargetRef.Name, cd.Spec.Image)
+		return c.promote(cd, status, target)
+	case examplev1beta1.CanaryGateAbort:
+		if err := c.clearGate(cd); err != nil {
+			return err
+		}
+		c.resume(cd, status, ReasonHeld)
+		return c.rollback(cd, status, target, "rollout aborted by the "+examplev1beta1.CanaryGateAnnotation+" annotation")
+	}
+	c.resume(cd, status, ReasonHeld)
+
+	// the rollback webhooks can abort the rollout until the promotion
+	if err := c.rollbackRequested(cd, status); err != nil {
+		return c.rollback(cd, status, target, fmt.Sprintf("rollback requested, %v", err))
+	}
+
+	if int(status.CurrentStep) >= len(steps) {
+		if err := c.callHooks(cd, status, examplev1beta1.CanaryWebhookConfirmPromotion); err != nil {
+			// the answer of the webhooks re-queues the canary, a refusal is asked again after the analysis interval
+			if !isHooksPending(err) {
+				c.enqueueAfter(cd, analysisInterval(cd))
+			}
+			setStatusCondition(cd, status, examplev1beta1.CanaryConditionReady, metav1.ConditionFalse,
+				"WaitingForConfirmation", fmt.Sprintf("Waiting for the promotion to be confirmed, %v", err))
+			return c.syncStatus(cd, *status)
+		}
+		return c.promote(cd, status, target)
+	}
+
+	// the canary Deployment is only created once the pre-rollout webhooks succeeded
+	if status.CurrentStep == 0 && len(hooksOf(cd, examplev1beta1.CanaryWebhookPreRollout)) > 0 && !c.canaryDeploymentExists(cd) {
+		preRollout := func() error { return c.callHooks(cd, status, examplev1beta1.CanaryWebhookPreRollout) }
+		if c.analyse(cd, status, preRollout) {
+			return c.rollback(cd, status, target, failedChecksCause(status))
+		}
+		if status.FailedChecks > 0 || isCheckPending(status) {
+			c.enqueueAfter(cd, analysisInterval(cd))
+			setStatusCondition(cd, status, examplev1beta1.CanaryConditionReady, metav1.ConditionFalse,
+				"WaitingForPreRollout", "Waiting for the pre-rollout webhooks")
+			return c.syncStatus(cd, *status)
+		}
+	}
+
+	weight := steps[status.CurrentStep]
+	canaryReplicas := canaryReplicasFor(cd.Spec.Replicas, weight)
+	canary, err := c.syncCanaryDeployment(cd, target, canaryReplicas)
+	if err != nil {
+		return c.failRollout(cd, status, err)
+	}
+
+	message := fmt.Sprintf("Step %d/%d, %d%% of the replicas run %s", status.CurrentStep+1, len(steps), weight, cd.Spec.Image)
+	setStatusCondition(cd, status, examplev1beta1.CanaryConditionReady, metav1.ConditionFalse, "Progressing", message)
+
+	check := func() error { return c.checkCanary(cd, status, canary) }
+	checked := isCheckDue(cd, status)
+	if c.analyse(cd, status, check) {
+		return c.rollback(cd, status, target, failedChecksCause(status))
+	}
+	// crash-looping pods do not always update the deployment, the checks re-queue the canary
+	c.enqueueAfter(cd, analysisInterval(cd))
+
+	// the deployment informer re-queues the canary while the pods start
+	if !isDeploymentReady(canary) || status.FailedChecks > 0 || isCheckPending(status) {
+		return c.syncStatus(cd, *status)
+	}
+
+	// the target only gives up its replicas once the canary pods serve the step
+	if _, err := c.scaleTarget(target, cd.Spec.Replicas-canaryReplicas, ""); err != nil {
+		return c.failRollout(cd, status, err)
+	}
+	status.CurrentWeight = weight
+
+	// the step starts now when the status predates the step start time or the rollout was reset
+	if status.StepStartTime == nil {
+		now := metav1.Now()
+		status.StepStartTime = &now
+	}
+	if wait := rolloutInterval(cd) - time.Since(status.StepStartTime.Time); wait > 0 {
+		c.enqueueAfter(cd, wait)
+		return c.syncStatus(cd, *status)
+	}
+
+	// the metrics and the rollout webhooks are evaluated right before the step is promoted
+	if !checked {
+		status.LastCheckTime = nil
+		if c.analyse(cd, status, check) {
+			return c.rollback(cd, status, target, failedChecksCause(status))
+		}
+		if status.FailedChecks > 0 || isCheckPending(status) {
+			return c.syncStatus(cd, *status)
+		}
+	}
+
+	status.CurrentStep++
+	now := metav1.Now()
+	status.StepStartTime = &now
+	if int(status.CurrentStep) < len(steps) {
+		c.recordEventInfof(cd, ReasonAdvanced, "Advance %s.%s to %d%% of the replicas", cd.Name, cd.Namespace, steps[status.CurrentStep])
+	}
+	// the status update re-queues the canary, re-queuing now would read a stale step from the cache
+	return c.syncStatus(cd, *status)
+}
+
+// promote updates the target to the canary image, the canary Deployment keeps
+// serving until the target is ready
+func (c *Controller) promote(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, target *appsv1.Deployment) error {
+	if _, err := c.scaleTarget(target, cd.Spec.Replicas, status.CanaryImage); err != nil {
+		return c.failRollout(cd, status, err)
+	}
+
+	c.recordEventInfof(cd, ReasonPromoting, "Promoting %s to %s", cd.Spec.TargetRef.Name, status.CanaryImage)
+	c.forgetHooks(cd)
+	status.StableImage = status.CanaryImage
+	status.CurrentWeight = 0
+	setStatusCondition(cd, status, examplev1beta1.CanaryConditionReady, metav1.ConditionFalse,
+		"Promoting", fmt.Sprintf("Deployment %s.%s is being promoted to %s", target.Name, target.Namespace, status.CanaryImage))
+	return c.syncStatus(cd, *status)
+}
+
+// rollback moves all the replicas of the target back to the stable image and removes
+// the canary Deployment, the canary image is cleared so that a retry starts over
+func (c *Controller) rollback(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, target *appsv1.Deployment, cause string) error {
+	if _, err := c.scaleTarget(target, cd.Spec.Replicas, status.StableImage); err != nil {
+		return c.failRollout(cd, status, err)
+	}
+	if err := c.deleteCanaryDeployment(cd); err != nil {
+		return c.failRollout(cd, status, err)
+	}
+	c.forgetHooks(cd)
+	status.CanaryImage = ""
+	status.CurrentStep = 0
+	status.CurrentWeight = 0
+	status.StepStartTime = nil
+	return c.markRolledBack(cd, status, target.Name, cause)
+}
+
+// syncPromoted keeps the target at full scale with the stable image once there is
+// nothing to roll out and removes the canary Deployment once the target is ready
+func (c *Controller) syncPromoted(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, target *appsv1.Deployment) error {
+	// a cache that has not seen the promotion yet is replaced by the latest version of the target
+	target, err := c.scaleTarget(target, cd.Spec.Replicas, status.StableImage)
+	if err != nil {
+		return c.failRollout(cd, status, err)
+	}
+	status.CurrentWeight = 0
+
+	// the target deployment events re-queue the canary while the rollout progresses,
+	// the readiness of the previous ReplicaSet does not count
+	if target.Spec.Template.Spec.Containers[0].Image != status.StableImage || !isDeploymentReady(target) {
+		setStatusPhase(status, examplev1beta1.CanaryPhaseProgressing)
+		setStatusCondition(cd, status, examplev1beta1.CanaryConditionReady, metav1.ConditionFalse,
+			"Promoting", fmt.Sprintf("Deployment %s.%s rollout in progress", target.Name, target.Namespace))
+		return c.syncStatus(cd, *status)
+	}
+	if err := c.deleteCanaryDeployment(cd); err != nil {
+		return c.failRollout(cd, status, err)
+	}
+
+	succeeded := status.Phase == examplev1beta1.CanaryPhaseSucceeded
+	setStatusPhase(status, examplev1beta1.CanaryPhaseSucceeded)
+	if !succeeded {
+		c.recordEventInfof(cd, ReasonSucceeded, "Successed canary %s.%s", cd.Name, cd.Namespace)
+		c.alert(cd, notifier.EventSucceeded, fmt.Sprintf("Rollout of %s succeeded", cd.Spec.Image), notifier.SeverityInfo)
+		c.callPostRolloutHooks(cd, status)
+	}
+	setStatusCondition(cd, status, examplev1beta1.CanaryConditionReady, metav1.ConditionTrue,
+		ReasonSynced, "Canary reconciled successfully")
+	return c.syncStatus(cd, *status)
+}
+
+func (c *Controller) failRollout(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus, err error) error {
+	if c.requeueOnConflict(cd, err) {
+		return nil
+	}
+	c.recordEventErrorf(cd, ReasonDeploymentSyncFailed, "Rollout of %s.%s failed: %v", cd.Name, cd.Namespace, err)
+	setStatusPhase(status, examplev1beta1.CanaryPhaseFailed)
+	setStatusCondition(cd, status, examplev1beta1.CanaryConditionReady, metav1.ConditionFalse,
+		ReasonDeploymentSyncFailed, err.Error())
+	if err := c.syncStatus(cd, *status); err != nil {
+		return err
+	}
+	return err
+}
+
+// getTarget returns the target Deployment, its pods must carry the selector of the canary
+func (c *Controller) getTarget(cd *examplev1beta1.Canary, selector map[string]string) (*appsv1.Deployment, error) {
+	inf, ok := c.informersFor(cd.Namespace)
+	if !ok {
+		return nil, fmt.Errorf("namespace %s is not watched", cd.Namespace)
+	}
+	target, err := inf.DeploymentInformer.Lister().Deployments(cd.Namespace).Get(cd.Spec.TargetRef.Name)
+	if err != nil {
+		return nil, fmt.Errorf("target deployment %s.%s get query error: %w", cd.Spec.TargetRef.Name, cd.Namespace, err)
+	}
+	if !labels.SelectorFromSet(selector).Matches(labels.Set(target.Spec.Template.Labels)) {
+		return nil, fmt.Errorf("pods of target deployment %s.%s are not labelled with %s",
+			target.Name, target.Namespace, labels.SelectorFromSet(selector))
+	}
+	if len(target.Spec.Template.Spec.Containers) == 0 {
+		return nil, fmt.Errorf("target deployment %s.%s has no container", target.Name, target.Namespace)
+	}
+	return target, nil
+}
+
+// scaleTarget sets the replicas of the target and the image of its first container when not empty,
+// the latest Deployment is returned when the cached one differs so that its readiness is not read
+// from a stale cache
+func (c *Controller) scaleTarget(target *appsv1.Deployment, replicas int32, image string) (*appsv1.Deployment, error) {
+	scale := func(dep *appsv1.Deployment) bool {
+		if len(dep.Spec.Template.Spec.Containers) == 0 {
+			return false
+		}
+		changed := dep.Spec.Replicas == nil || *dep.Spec.Replicas != replicas
+		if image != "" && dep.Spec.Template.Spec.Containers[0].Image != image {
+			changed = true
+		}
+		dep.Spec.Replicas = &replicas
+		if image != "" {
+			dep.Spec.Template.Spec.Containers[0].Image = image
+		}
+		return changed
+	}
+	if !scale(target.DeepCopy()) {
+		return target, nil
+	}
+
+	updated, err := c.updateDeployment(target.Namespace, target.Name, scale)
+	if err != nil {
+		return nil, fmt.Errorf("target deployment %s.%s update error: %w", target.Name, target.Namespace, err)
+	}
+	return updated, nil
+}
+
+// syncCanaryDeployment creates or updates the Deployment running the canary image
+func (c *Controller) syncCanaryDeployment(cd *examplev1beta1.Canary, target *appsv1.Deployment, replicas int32) (*appsv1.Deployment, error) {
+	desired := newCanaryDeployment(cd, target, replicas)
+
+	inf, ok := c.informersFor(cd.Namespace)
+	if !ok {
+		return nil, fmt.Errorf("namespace %s is not watched", cd.Namespace)
+	}
+	dep, err := inf.DeploymentInformer.Lister().Deployments(cd.Namespace).Get(desired.Name)
+	if errors.IsNotFound(err) {
+		dep, err = c.kubeClient.AppsV1().Deployments(cd.Namespace).Create(context.TODO(), desired, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("deployment %s.%s create error: %w", desired.Name, desired.Namespace, err)
+		}
+		c.recordEventInfof(cd, ReasonDeploymentCreated, "Deployment %s.%s created", desired.Name, desired.Namespace)
+		return dep, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("deployment %s.%s get query error: %w", desired.Name, desired.Namespace, err)
+	}
+	if !metav1.IsControlledBy(dep, cd) {
+		return nil, fmt.Errorf("deployment %s.%s already exists and is not managed by canary %s.%s",
+			dep.Name, dep.Namespace, cd.Name, cd.Namespace)
+	}
+
+	scale := func(dep *appsv1.Deployment) bool {
+		// containers removed by hand are restored from the target
+		if len(dep.Spec.Template.Spec.Containers) == 0 {
+			dep.Spec.Replicas = &replicas
+			dep.Spec.Template.Spec.Containers = desired.Spec.Template.Spec.Containers
+			return true
+		}
+		if dep.Spec.Replicas != nil && *dep.Spec.Replicas == replicas &&
+			dep.Spec.Template.Spec.Containers[0].Image == cd.Spec.Image {
+			return false
+		}
+		dep.Spec.Replicas = &replicas
+		dep.Spec.Template.Spec.Containers[0].Image = cd.Spec.Image
+		return true
+	}
+	if scale(dep.DeepCopy()) {
+		dep, err = c.updateDeployment(dep.Namespace, dep.Name, scale)
+		if err != nil {
+			return nil, fmt.Errorf("deployment %s.%s update error: %w", desired.Name, desired.Namespace, err)
+		}
+	}
+	return dep, nil
+}
+
+func (c *Controller) canaryDeploymentExists(cd *examplev1beta1.Canary) bool {
+	inf, ok := c.informersFor(cd.Namespace)
+	if !ok {
+		return false
+	}
+	_, err := inf.DeploymentInformer.Lister().Deployments(cd.Namespace).Get(canaryDeploymentName(cd))
+	return err == nil
+}
+
+func (c *Controller) deleteCanaryDeployment(cd *examplev1beta1.Canary) error {
+	name := canaryDeploymentName(cd)
+	if inf, ok := c.informersFor(cd.Namespace); ok {
+		if _, err := inf.DeploymentInformer.Lister().Deployments(cd.Namespace).Get(name); errors.IsNotFound(err) {
+			return nil
+		}
+	}
+	err := c.kubeClient.AppsV1().Deployments(cd.Namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("deployment %s.%s delete error: %w", name, cd.Namespace, err)
+	}
+	return nil
+}
+
+// newCanaryDeployment copies the pod template of the target with the canary image
+// in the first container, the pods keep the labels of the target so that they
+// receive a share of the traffic of its services
+func newCanaryDeployment(cd *examplev1beta1.Canary, target *appsv1.Deployment, replicas int32) *appsv1.Deployment {
+	template := *target.Spec.Template.DeepCopy()
+	if template.Labels == nil {
+		template.Labels = map[string]string{}
+	}
+	template.Labels[canaryLabel] = cd.Name
+	template.Spec.Containers[0].Image = cd.Spec.Image
+
+	selector := target.Spec.Selector.DeepCopy()
+	if selector.MatchLabels == nil {
+		selector.MatchLabels = map[string]string{}
+	}
+	selector.MatchLabels[canaryLabel] = cd.Name
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      canaryDeploymentName(cd),
+			Namespace: cd.Namespace,
+			Labels:    map[string]string{canaryLabel: cd.Name},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(cd, examplev1beta1.SchemeGroupVersion.WithKind("Canary")),
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: selector,
+			Template: template,
+		},
+	}
+}
+
+func canaryDeploymentName(cd *examplev1beta1.Canary) string {
+	return cd.Spec.TargetRef.Name + "-canary"
+}
+
+// canaryReplicasFor rounds up so that every step but 0% runs at least one canary pod
+func canaryReplicasFor(replicas, weight int32) int32 {
+	return (replicas*weight + 99) / 100
+}
+
+func rolloutSteps(cd *examplev1beta1.Canary) []int32 {
+	if len(cd.Spec.Steps) == 0 {
+		return defaultSteps
+	}
+	return cd.Spec.Steps
+}
+
+func rolloutInterval(cd *examplev1beta1.Canary) time.Duration {
+	if cd.Spec.Interval == nil {
+		return examplev1beta1.DefaultStepInterval
+	}
+	return cd.Spec.Interval.Duration
+}
+
+func (c *Controller) enqueueAfter(cd *examplev1beta1.Canary, wait time.Duration) {
+	key, err := cache.MetaNamespaceKeyFunc(cd)
+	if err != nil {
+		return
+	}
+	c.workqueue.AddAfter(key, wait)
+}
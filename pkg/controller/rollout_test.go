@@ -0,0 +1,335 @@
+package controller
+
+import (
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"testing"
+	"time"
+)
+
+// newTarget returns a ready Deployment targeted by the rollout canaries
+func newTarget(image string, replicas int32) *appsv1.Deployment {
+	labels := map[string]string{"app": "podinfo"}
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "test", Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "podinfo", Image: image}},
+				},
+			},
+		},
+	}
+	markReady(dep)
+	return dep
+}
+
+// newRolloutCanary returns a canary rolling the image out to the target, the steps
+// are not delayed and every reconcile runs the checks
+func newRolloutCanary(image string, steps ...int32) *examplev1beta1.Canary {
+	cd := newCanary(image)
+	cd.Spec.TargetRef = &examplev1beta1.CanaryTargetReference{Name: "podinfo"}
+	cd.Spec.Steps = steps
+	cd.Spec.Interval = &metav1.Duration{}
+	cd.Spec.Analysis = &examplev1beta1.CanaryAnalysis{Interval: &metav1.Duration{}, Threshold: 2}
+	return cd
+}
+
+// inProgress sets the status of a rollout of podinfo:2.0 paused at a step
+func inProgress(cd *examplev1beta1.Canary, step, weight int32) *examplev1beta1.Canary {
+	start := metav1.Now()
+	cd.Finalizers = []string{finalizerName}
+	cd.Status = examplev1beta1.CanaryStatus{
+		Phase:              examplev1beta1.CanaryPhaseProgressing,
+		ObservedGeneration: cd.Generation,
+		StableImage:        "podinfo:1.0",
+		CanaryImage:        "podinfo:2.0",
+		CurrentStep:        step,
+		CurrentWeight:      weight,
+		StepStartTime:      &start,
+	}
+	return cd
+}
+
+// newCanaryTarget returns the ready canary Deployment of the target
+func newCanaryTarget(cd *examplev1beta1.Canary, replicas int32) *appsv1.Deployment {
+	dep := newCanaryDeployment(cd, newTarget("podinfo:1.0", 4), replicas)
+	markReady(dep)
+	return dep
+}
+
+func image(dep *appsv1.Deployment) string {
+	return dep.Spec.Template.Spec.Containers[0].Image
+}
+
+func TestRolloutSteps(t *testing.T) {
+	tests := []struct {
+		steps    []int32
+		replicas int32
+		expected []int32
+	}{
+		{nil, 4, []int32{4}},
+		{[]int32{10, 50, 100}, 4, []int32{1, 2, 4}},
+		{[]int32{0, 33, 66}, 3, []int32{0, 1, 2}},
+		{[]int32{1}, 1, []int32{1}},
+	}
+
+	for _, tt := range tests {
+		cd := newRolloutCanary("podinfo:2.0", tt.steps...)
+		cd.Spec.Replicas = tt.replicas
+		steps := rolloutSteps(cd)
+		if len(steps) != len(tt.expected) {
+			t.Fatalf("expected %d steps for %v, got %v", len(tt.expected), tt.steps, steps)
+		}
+		for i, weight := range steps {
+			if replicas := canaryReplicasFor(tt.replicas, weight); replicas != tt.expected[i] {
+				t.Errorf("expected %d canary replicas at %d%% of %d, got %d", tt.expected[i], weight, tt.replicas, replicas)
+			}
+		}
+	}
+}
+
+func TestSyncHandler_RolloutSteps(t *testing.T) {
+	f := newFixture(t, newRolloutCanary("podinfo:2.0", 25, 50), newTarget("podinfo:1.0", 4))
+
+	// the first step creates the canary Deployment and waits for its pods
+	f.sync()
+	cd := f.canary()
+	if cd.Status.StableImage != "podinfo:1.0" || cd.Status.CanaryImage != "podinfo:2.0" || cd.Status.CurrentStep != 0 {
+		t.Fatalf("expected the rollout to start at the first step, got %+v", cd.Status)
+	}
+	if !hasEvent(f.recordedEvents(), "Normal RolloutStarted") {
+		t.Error("expected a RolloutStarted event")
+	}
+	canary := f.deployment("podinfo-canary")
+	if canary == nil || image(canary) != "podinfo:2.0" || *canary.Spec.Replicas != 1 {
+		t.Fatalf("expected a canary Deployment with 1 replica of podinfo:2.0, got %+v", canary)
+	}
+	if target := f.deployment("podinfo"); *target.Spec.Replicas != 4 {
+		t.Errorf("expected the target to keep its replicas until the canary is ready, got %d", *target.Spec.Replicas)
+	}
+
+	// the target gives up a replica once the canary pod is ready
+	f.rollOut("podinfo-canary")
+	f.sync()
+	cd = f.canary()
+	if cd.Status.CurrentStep != 1 || cd.Status.CurrentWeight != 25 {
+		t.Fatalf("expected the rollout to advance to the second step, got %+v", cd.Status)
+	}
+	if target := f.deployment("podinfo"); *target.Spec.Replicas != 3 {
+		t.Errorf("expected the target to be scaled to 3 replicas, got %d", *target.Spec.Replicas)
+	}
+	if !hasEvent(f.recordedEvents(), "Normal Advanced") {
+		t.Error("expected an Advanced event")
+	}
+
+	// the second step
+	f.sync()
+	f.rollOut("podinfo-canary")
+	f.sync()
+	cd = f.canary()
+	if cd.Status.CurrentStep != 2 || cd.Status.CurrentWeight != 50 {
+		t.Fatalf("expected the last step to be done, got %+v", cd.Status)
+	}
+
+	// the promotion updates the target and keeps the canary until the target is ready
+	f.sync()
+	target := f.deployment("podinfo")
+	if image(target) != "podinfo:2.0" || *target.Spec.Replicas != 4 {
+		t.Fatalf("expected the target to be promoted, got %s with %d replicas", image(target), *target.Spec.Replicas)
+	}
+	f.sync()
+	if cd = f.canary(); cd.Status.Phase != examplev1beta1.CanaryPhaseProgressing || f.deployment("podinfo-canary") == nil {
+		t.Fatalf("expected the canary Deployment to be kept while the target rolls out, got %s", cd.Status.Phase)
+	}
+
+	f.rollOut("podinfo")
+	f.sync()
+	cd = f.canary()
+	if cd.Status.Phase != examplev1beta1.CanaryPhaseSucceeded || cd.Status.StableImage != "podinfo:2.0" {
+		t.Errorf("expected the rollout to succeed, got %+v", cd.Status)
+	}
+	if f.deployment("podinfo-canary") != nil {
+		t.Error("expected the canary Deployment to be deleted")
+	}
+	events := f.recordedEvents()
+	if !hasEvent(events, "Normal Promoting") || !hasEvent(events, "Normal Succeeded") {
+		t.Errorf("expected Promoting and Succeeded events, got %v", events)
+	}
+}
+
+func TestSyncHandler_RolloutResumesFromStatus(t *testing.T) {
+	cd := inProgress(newRolloutCanary("podinfo:2.0", 25, 50), 1, 25)
+	cd.Spec.Interval = &metav1.Duration{Duration: time.Hour}
+	f := newFixture(t, cd, newTarget("podinfo:1.0", 3), newCanaryTarget(cd, 1))
+
+	// a restarted controller continues with the step of the status
+	f.sync()
+	got := f.canary()
+	if got.Status.CurrentStep != 1 || got.Status.CanaryImage != "podinfo:2.0" {
+		t.Fatalf("expected the rollout to stay at the second step, got %+v", got.Status)
+	}
+	if !got.Status.StepStartTime.Equal(cd.Status.StepStartTime) {
+		t.Errorf("expected the step start time to be kept, got %v", got.Status.StepStartTime)
+	}
+	if canary := f.deployment("podinfo-canary"); *canary.Spec.Replicas != 2 {
+		t.Errorf("expected the canary to be scaled to the second step, got %d replicas", *canary.Spec.Replicas)
+	}
+	if events := f.recordedEvents(); hasEvent(events, "Normal RolloutStarted") {
+		t.Errorf("expected the rollout not to restart, got %v", events)
+	}
+
+	// the step waits for its interval once the canary is ready
+	f.rollOut("podinfo-canary")
+	f.sync()
+	if got = f.canary(); got.Status.CurrentStep != 1 || got.Status.CurrentWeight != 50 {
+		t.Errorf("expected the step to wait for its interval, got %+v", got.Status)
+	}
+}
+
+func TestSyncHandler_RolloutWithoutStepStartTime(t *testing.T) {
+	cd := inProgress(newRolloutCanary("podinfo:2.0", 25, 50), 1, 25)
+	cd.Spec.Interval = &metav1.Duration{Duration: time.Hour}
+	cd.Status.StepStartTime = nil
+	f := newFixture(t, cd, newTarget("podinfo:1.0", 2), newCanaryTarget(cd, 2))
+
+	// a status written before the step start time existed starts the step now
+	f.sync()
+	got := f.canary()
+	if got.Status.StepStartTime == nil {
+		t.Fatal("expected the step to be started")
+	}
+	if got.Status.CurrentStep != 1 || got.Status.CurrentWeight != 50 {
+		t.Errorf("expected the step to wait for its interval, got %+v", got.Status)
+	}
+}
+
+func TestSyncHandler_PromotionWaitsForTheNewImage(t *testing.T) {
+	cd := inProgress(newRolloutCanary("podinfo:2.0", 50), 0, 0)
+	cd.Annotations = map[string]string{examplev1beta1.CanaryGateAnnotation: examplev1beta1.CanaryGateApprove}
+	f := newFixture(t, cd, newTarget("podinfo:1.0", 4), newCanaryTarget(cd, 2))
+
+	f.sync()
+	if target := f.deployment("podinfo"); image(target) != "podinfo:2.0" {
+		t.Fatalf("expected the target to be promoted, got %s", image(target))
+	}
+
+	// the canary update is seen before the target update, the cached target
+	// still runs all its replicas of the previous image
+	f.syncCanaries()
+	f.inf.DeploymentInformer.Informer().GetIndexer().Update(newTarget("podinfo:1.0", 4))
+	if err := f.ctrl.syncHandler(testKey); err != nil {
+		t.Fatal(err)
+	}
+	f.syncCanaries()
+	if got := f.canary(); got.Status.Phase == examplev1beta1.CanaryPhaseSucceeded {
+		t.Fatalf("expected the promotion to wait for the new image, got %+v", readyCondition(got))
+	}
+	if f.deployment("podinfo-canary") == nil {
+		t.Fatal("expected the canary Deployment to be kept until the target is ready")
+	}
+
+	f.rollOut("podinfo")
+	f.sync()
+	if got := f.canary(); got.Status.Phase != examplev1beta1.CanaryPhaseSucceeded {
+		t.Errorf("expected the rollout to succeed, got %s", got.Status.Phase)
+	}
+	if f.deployment("podinfo-canary") != nil {
+		t.Error("expected the canary Deployment to be deleted")
+	}
+}
+
+func TestSyncHandler_RolloutConflict(t *testing.T) {
+	cd := inProgress(newRolloutCanary("podinfo:2.0", 50), 0, 0)
+	f := newFixture(t, cd, newTarget("podinfo:1.0", 4), newCanaryTarget(cd, 2))
+	f.conflictOnUpdate("deployments", -1)
+
+	f.sync()
+	got := f.canary()
+	if got.Status.Phase != examplev1beta1.CanaryPhaseProgressing || got.Status.CurrentWeight != 0 {
+		t.Errorf("expected the step to be retried, got %s at %d%%", got.Status.Phase, got.Status.CurrentWeight)
+	}
+	if events := f.recordedEvents(); hasWarning(events) {
+		t.Errorf("expected conflicts not to be reported, got %v", events)
+	}
+	if messages := f.notifier.posted(); len(messages) != 0 {
+		t.Errorf("expected no alert, got %v", messages)
+	}
+}
+
+func TestSyncHandler_Rollback(t *testing.T) {
+	cd := newRolloutCanary("podinfo:2.0", 50)
+	crashLooping := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "podinfo-canary-1",
+			Namespace: "test",
+			Labels:    map[string]string{"app": "podinfo", canaryLabel: "podinfo"},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "podinfo",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+			}},
+		},
+	}
+	f := newFixture(t, []runtime.Object{cd, newTarget("podinfo:1.0", 4), crashLooping}...)
+
+	// the first failed check is below the threshold
+	f.sync()
+	if got := f.canary(); got.Status.FailedChecks != 1 || f.deployment("podinfo-canary") == nil {
+		t.Fatalf("expected one failed check, got %+v", got.Status)
+	}
+	if !hasEvent(f.recordedEvents(), "Warning CheckFailed") {
+		t.Error("expected a CheckFailed event")
+	}
+
+	f.sync()
+	got := f.canary()
+	if got.Status.Phase != examplev1beta1.CanaryPhaseFailed || readyCondition(got).Reason != ReasonRolledBack {
+		t.Fatalf("expected the canary to be rolled back, got %+v", readyCondition(got))
+	}
+	if f.deployment("podinfo-canary") != nil {
+		t.Error("expected the canary Deployment to be deleted")
+	}
+	if target := f.deployment("podinfo"); image(target) != "podinfo:1.0" || *target.Spec.Replicas != 4 {
+		t.Errorf("expected the target to run 4 replicas of podinfo:1.0, got %d of %s", *target.Spec.Replicas, image(target))
+	}
+	if !hasEvent(f.recordedEvents(), "Warning RolledBack") {
+		t.Error("expected a RolledBack event")
+	}
+
+	// the rollback holds until the spec changes
+	f.sync()
+	f.sync()
+	if f.deployment("podinfo-canary") != nil {
+		t.Fatal("expected the rolled back canary not to be retried")
+	}
+
+	f.updateCanary(func(cd *examplev1beta1.Canary) {
+		cd.Generation++
+		cd.Spec.Image = "podinfo:2.1"
+	})
+	f.sync()
+	if canary := f.deployment("podinfo-canary"); canary == nil || image(canary) != "podinfo:2.1" {
+		t.Errorf("expected the new image to be rolled out, got %+v", canary)
+	}
+}
+
+func TestSyncHandler_CanaryWithoutContainers(t *testing.T) {
+	cd := inProgress(newRolloutCanary("podinfo:2.0", 50), 0, 50)
+	canary := newCanaryTarget(cd, 2)
+	canary.Spec.Template.Spec.Containers = nil
+	f := newFixture(t, cd, newTarget("podinfo:1.0", 2), canary)
+
+	f.sync()
+
+	if got := f.deployment("podinfo-canary"); len(got.Spec.Template.Spec.Containers) != 1 || image(got) != "podinfo:2.0" {
+		t.Errorf("expected the canary containers to be restored, got %+v", got.Spec.Template.Spec.Containers)
+	}
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/cron"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"time"
+)
+
+// syncSchedule records the schedule times in the status and re-queues the canary
+// for the next activation, it returns false when the cron expression is invalid
+func (c *Controller) syncSchedule(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus) bool {
+	schedule, err := cron.Parse(cd.Spec.Cron)
+	if err != nil {
+		// only warn once per generation, the canary is re-queued by every resync
+		if !hasStatusCondition(cd, status, examplev1beta1.CanaryConditionScheduled, metav1.ConditionFalse, ReasonInvalidSchedule) {
+			c.recordEventWarningf(cd, ReasonInvalidSchedule, "Invalid cron expression %q: %v", cd.Spec.Cron, err)
+		}
+		setStatusPhase(status, examplev1beta1.CanaryPhaseFailed)
+		setStatusCondition(cd, status, examplev1beta1.CanaryConditionScheduled, metav1.ConditionFalse,
+			ReasonInvalidSchedule, fmt.Sprintf("Invalid cron expression %q: %v", cd.Spec.Cron, err))
+		status.NextScheduleTime = nil
+		return false
+	}
+
+	now := time.Now()
+	if status.NextScheduleTime != nil && !now.Before(status.NextScheduleTime.Time) {
+		fired := *status.NextScheduleTime
+		status.LastScheduleTime = &fired
+		c.recordEventInfof(cd, ReasonScheduled, "Scheduled run of canary %s.%s at %s", cd.Name, cd.Namespace,
+			fired.Format(time.RFC3339))
+	}
+
+	next := schedule.Next(now)
+	if next.IsZero() {
+		setStatusCondition(cd, status, examplev1beta1.CanaryConditionScheduled, metav1.ConditionFalse,
+			"ScheduleExhausted", fmt.Sprintf("Cron expression %q has no future activation", cd.Spec.Cron))
+		status.NextScheduleTime = nil
+		return true
+	}
+
+	status.NextScheduleTime = &metav1.Time{Time: next}
+	setStatusCondition(cd, status, examplev1beta1.CanaryConditionScheduled, metav1.ConditionTrue,
+		ReasonScheduled, fmt.Sprintf("Next run at %s", next.Format(time.RFC3339)))
+
+	key, err := cache.MetaNamespaceKeyFunc(cd)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return true
+	}
+	c.workqueue.AddAfter(key, next.Sub(now))
+	return true
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// setStatusPhase changes the phase and bumps the transition time when the phase differs
+func setStatusPhase(status *examplev1beta1.CanaryStatus, phase examplev1beta1.CanaryPhase) {
+	if status.Phase != phase {
+		status.Phase = phase
+		status.LastTransitionTime = metav1.Now()
+	}
+}
+
+// setStatusCondition upserts a condition, the transition time only changes with the condition status
+func setStatusCondition(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus,
+	conditionType string, conditionStatus metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		ObservedGeneration: cd.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// hasStatusCondition returns true when the condition has already been set
+// with the same status and reason for the current generation
+func hasStatusCondition(cd *examplev1beta1.Canary, status *examplev1beta1.CanaryStatus,
+	conditionType string, conditionStatus metav1.ConditionStatus, reason string) bool {
+	cond := meta.FindStatusCondition(status.Conditions, conditionType)
+	return cond != nil && cond.Status == conditionStatus && cond.Reason == reason &&
+		cond.ObservedGeneration == cd.Generation
+}
+
+// syncStatus writes the status through the /status subresource,
+// the API call is skipped when nothing changed to avoid update loops
+func (c *Controller) syncStatus(cd *examplev1beta1.Canary, status examplev1beta1.CanaryStatus) error {
+	if equality.Semantic.DeepEqual(cd.Status, status) {
+		return nil
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		latest, err := c.exampleClient.ExampleV1beta1().Canaries(cd.Namespace).Get(context.TODO(), cd.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		cdCopy := latest.DeepCopy()
+		cdCopy.Status = status
+		_, err = c.exampleClient.ExampleV1beta1().Canaries(cd.Namespace).UpdateStatus(context.TODO(), cdCopy, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		c.recordEventErrorf(cd, ReasonStatusUpdateFailed, "Status update of canary %s.%s failed: %v", cd.Name, cd.Namespace, err)
+		return fmt.Errorf("updating status of canary %s.%s failed: %w", cd.Name, cd.Namespace, err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
+	"io/ioutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"time"
+)
+
+const (
+	eventWebhookRetries = 3
+
+	// eventQueueSize bounds the events waiting to be published to the event webhook
+	eventQueueSize = 100
+	// eventDrainTimeout bounds the publishing of the events still queued on shutdown
+	eventDrainTimeout = 10 * time.Second
+	// eventWebhookProvider labels the event webhook in the notification metrics
+	eventWebhookProvider = "event-webhook"
+)
+
+// sendEventToWebhook queues the event for the event webhook so that a slow
+// receiver can't stall the reconcile workers, the event is dropped when the queue is full
+func (c *Controller) sendEventToWebhook(r *examplev1beta1.Canary, eventType, reason, template string, args []interface{}) {
+	if c.eventWebhook == "" {
+		return
+	}
+
+	payload := examplev1beta1.CanaryEventPayload{
+		APIVersion: examplev1beta1.CanaryEventPayloadVersion,
+		Kind:       "CanaryEvent",
+		Name:       r.Name,
+		Namespace:  r.Namespace,
+		Phase:      r.Status.Phase,
+		EventType:  eventType,
+		Reason:     reason,
+		Message:    fmt.Sprintf(template, args...),
+		Timestamp:  metav1.Now(),
+	}
+
+	select {
+	case c.events <- payload:
+	default:
+		c.recorder.IncNotification(eventWebhookProvider, notifier.ErrQueueFull)
+		c.logger.With("canary", fmt.Sprintf("%s.%s", r.Name, r.Namespace)).
+			Errorf("error sending event to webhook: %v", notifier.ErrQueueFull)
+	}
+}
+
+// publishEvents posts the queued events until stopCh is closed, the events still
+// queued are then posted for up to eventDrainTimeout, the notifier client gives up
+// its retries on shutdown
+func (c *Controller) publishEvents(stopCh <-chan struct{}) {
+	if c.eventWebhook == "" {
+		return
+	}
+
+	for {
+		select {
+		case payload := <-c.events:
+			c.publishEvent(payload)
+		case <-stopCh:
+			deadline := time.Now().Add(eventDrainTimeout)
+			for time.Now().Before(deadline) {
+				select {
+				case payload := <-c.events:
+					c.publishEvent(payload)
+				default:
+					return
+				}
+			}
+			return
+		}
+	}
+}
+
+func (c *Controller) publishEvent(payload examplev1beta1.CanaryEventPayload) {
+	err := c.notifierClient.PostJSON(c.eventWebhook, payload)
+	c.recorder.IncNotification(eventWebhookProvider, err)
+	if err != nil {
+		c.logger.With("canary", fmt.Sprintf("%s.%s", payload.Name, payload.Namespace)).
+			Errorf("error sending event to webhook: %s", err)
+	}
+}
+
+// callWebhook posts the payload as JSON, failed attempts are retried with
+// an exponential backoff and any 2xx status code is treated as success
+func callWebhook(address string, payload interface{}, timeout time.Duration, retries int) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshalling webhook payload failed: %w", err)
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err = postWebhook(address, data, timeout)
+		if err == nil || attempt >= retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func postWebhook(address string, data []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, address, bytes.NewBuffer(data))
+	if err != nil {
+		return fmt.Errorf("http.NewRequest failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending webhook request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("webhook %s returned %d: %s", address, res.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventReceiver answers the event webhook with the status codes in order, 200 once they are used up
+type eventReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	attempts int
+	events   []map[string]interface{}
+}
+
+func (r *eventReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	event := map[string]interface{}{}
+	if err := json.Unmarshal(body, &event); err == nil {
+		r.events = append(r.events, event)
+	}
+}
+
+func (r *eventReceiver) received() (int, []map[string]interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.attempts, append([]map[string]interface{}{}, r.events...)
+}
+
+// withEventWebhook points the controller of the fixture to the receiver, the dead
+// letters of the events that could not be delivered are returned
+func withEventWebhook(t *testing.T, f *fixture, receiver *eventReceiver) *notifier.DeadLetterQueue {
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	deadLetters := notifier.NewDeadLetterQueue(10)
+	f.ctrl.eventWebhook = server.URL
+	f.ctrl.events = make(chan examplev1beta1.CanaryEventPayload, eventQueueSize)
+	f.ctrl.notifierClient = notifier.NewClient(context.Background(), notifier.RetryPolicy{
+		Retries:    2,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+		Timeout:    time.Second,
+	}, deadLetters)
+	return deadLetters
+}
+
+// publish drains the queued events
+func (f *fixture) publish() {
+	stopCh := make(chan struct{})
+	close(stopCh)
+	f.ctrl.publishEvents(stopCh)
+}
+
+func TestSendEventToWebhook_Payload(t *testing.T) {
+	f := newFixture(t)
+	receiver := &eventReceiver{}
+	withEventWebhook(t, f, receiver)
+
+	cd := newCanary("podinfo:2.0")
+	cd.Status.Phase = examplev1beta1.CanaryPhaseProgressing
+	f.ctrl.recordEventWarningf(cd, ReasonCheckFailed, "Check %d failed", 1)
+	f.publish()
+
+	_, events := receiver.received()
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %v", events)
+	}
+	expected := map[string]interface{}{
+		"apiVersion": examplev1beta1.CanaryEventPayloadVersion,
+		"kind":       "CanaryEvent",
+		"name":       "podinfo",
+		"namespace":  "test",
+		"phase":      string(examplev1beta1.CanaryPhaseProgressing),
+		"eventType":  "Warning",
+		"reason":     ReasonCheckFailed,
+		"message":    "Check 1 failed",
+	}
+	for key, value := range expected {
+		if events[0][key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, events[0][key])
+		}
+	}
+	if _, err := time.Parse(time.RFC3339, events[0]["timestamp"].(string)); err != nil {
+		t.Errorf("expected an RFC 3339 timestamp, got %v", events[0]["timestamp"])
+	}
+}
+
+func TestSendEventToWebhook_Delivery(t *testing.T) {
+	tests := []struct {
+		name        string
+		statuses    []int
+		attempts    int
+		delivered   bool
+		deadLetters int
+	}{
+		{"delivered", nil, 1, true, 0},
+		{"server errors are retried", []int{http.StatusServiceUnavailable, http.StatusBadGateway}, 3, true, 0},
+		{"retries are bounded", []int{500, 500, 500, 500}, 3, false, 1},
+		{"client errors are not retried", []int{http.StatusBadRequest}, 1, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			receiver := &eventReceiver{statuses: tt.statuses}
+			deadLetters := withEventWebhook(t, f, receiver)
+
+			f.ctrl.recordEventInfof(newCanary("podinfo:2.0"), ReasonSynced, "Synced")
+			f.publish()
+
+			attempts, events := receiver.received()
+			if attempts != tt.attempts {
+				t.Errorf("expected %d attempts, got %d", tt.attempts, attempts)
+			}
+			if delivered := len(events) == 1; delivered != tt.delivered {
+				t.Errorf("expected delivered to be %t, got %v", tt.delivered, events)
+			}
+			if letters := deadLetters.List(); len(letters) != tt.deadLetters {
+				t.Errorf("expected %d dead letters, got %+v", tt.deadLetters, letters)
+			}
+		})
+	}
+}
+
+func TestSendEventToWebhook_BoundedQueue(t *testing.T) {
+	f := newFixture(t)
+	receiver := &eventReceiver{}
+	withEventWebhook(t, f, receiver)
+
+	// nothing publishes the events, the queue overflows instead of blocking the caller
+	cd := newCanary("podinfo:2.0")
+	for i := 0; i < eventQueueSize+5; i++ {
+		f.ctrl.sendEventToWebhook(cd, "Normal", ReasonSynced, "Synced %d", []interface{}{i})
+	}
+
+	// the queued events are published on shutdown
+	f.publish()
+	if _, events := receiver.received(); len(events) != eventQueueSize {
+		t.Errorf("expected the %d queued events to be published, got %d", eventQueueSize, len(events))
+	}
+}
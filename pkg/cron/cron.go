@@ -0,0 +1,274 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression, each field is stored as a bit set
+type Schedule struct {
+	second, minute, hour, dom, month, dow uint64
+	// years is nil when any year matches
+	years map[int]bool
+	// domAny and dowAny record whether the day fields were left open with * or ?
+	domAny, dowAny bool
+}
+
+type bounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	secondBounds = bounds{0, 59, nil}
+	minuteBounds = bounds{0, 59, nil}
+	hourBounds   = bounds{0, 23, nil}
+	domBounds    = bounds{1, 31, nil}
+	monthBounds  = bounds{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// standard cron week days, 0 and 7 are both Sunday
+	dowBounds = bounds{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+	// Quartz week days, 1 is Sunday and 7 is Saturday
+	quartzDowBounds = bounds{1, 7, map[string]int{
+		"sun": 1, "mon": 2, "tue": 3, "wed": 4, "thu": 5, "fri": 6, "sat": 7,
+	}}
+	yearBounds = bounds{1970, 2099, nil}
+)
+
+// Parse accepts a standard 5-field expression (minute hour day-of-month month day-of-week)
+// or a Quartz 6/7-field expression (second minute hour day-of-month month day-of-week [year])
+func Parse(spec string) (*Schedule, error) {
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		return parseFields(append([]string{"0"}, fields...), false)
+	case 6, 7:
+		return parseFields(fields, true)
+	default:
+		return nil, fmt.Errorf("expected 5, 6 or 7 fields, found %d in %q", len(fields), spec)
+	}
+}
+
+func parseFields(fields []string, quartz bool) (*Schedule, error) {
+	var err error
+	s := &Schedule{}
+
+	if s.second, err = parseField(fields[0], secondBounds); err != nil {
+		return nil, fmt.Errorf("invalid second field: %w", err)
+	}
+	if s.minute, err = parseField(fields[1], minuteBounds); err != nil {
+		return nil, fmt.Errorf("invalid minute field: %w", err)
+	}
+	if s.hour, err = parseField(fields[2], hourBounds); err != nil {
+		return nil, fmt.Errorf("invalid hour field: %w", err)
+	}
+	if s.dom, err = parseField(fields[3], domBounds); err != nil {
+		return nil, fmt.Errorf("invalid day-of-month field: %w", err)
+	}
+	if s.month, err = parseField(fields[4], monthBounds); err != nil {
+		return nil, fmt.Errorf("invalid month field: %w", err)
+	}
+
+	if quartz {
+		dow, err := parseField(fields[5], quartzDowBounds)
+		if err != nil {
+			return nil, fmt.Errorf("invalid day-of-week field: %w", err)
+		}
+		// shift Quartz days (1-7) to time.Weekday (0-6)
+		s.dow = dow >> 1
+	} else {
+		dow, err := parseField(fields[5], dowBounds)
+		if err != nil {
+			return nil, fmt.Errorf("invalid day-of-week field: %w", err)
+		}
+		// fold 7 onto Sunday
+		if dow&(1<<7) != 0 {
+			dow = dow&^(1<<7) | 1
+		}
+		s.dow = dow
+	}
+
+	s.domAny = isAny(fields[3])
+	s.dowAny = isAny(fields[5])
+	if quartz && fields[3] == "?" && fields[5] == "?" {
+		return nil, fmt.Errorf("'?' can not be used for both day-of-month and day-of-week")
+	}
+
+	if len(fields) == 7 && !isAny(fields[6]) {
+		years, err := expandField(fields[6], yearBounds)
+		if err != nil {
+			return nil, fmt.Errorf("invalid year field: %w", err)
+		}
+		s.years = map[int]bool{}
+		for _, y := range years {
+			s.years[y] = true
+		}
+	}
+
+	return s, nil
+}
+
+func isAny(field string) bool {
+	return field == "*" || field == "?"
+}
+
+// parseField returns a bit set of the values matched by a comma separated list of ranges
+func parseField(field string, b bounds) (uint64, error) {
+	values, err := expandField(field, b)
+	if err != nil {
+		return 0, err
+	}
+	var bits uint64
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+	return bits, nil
+}
+
+// expandField lists the values matched by a comma separated list of ranges
+func expandField(field string, b bounds) ([]int, error) {
+	var values []int
+	for _, expr := range strings.Split(field, ",") {
+		lo, hi, step, err := parseRange(expr, b)
+		if err != nil {
+			return nil, err
+		}
+		for i := lo; i <= hi; i += step {
+			values = append(values, i)
+		}
+	}
+	return values, nil
+}
+
+func parseRange(expr string, b bounds) (lo, hi, step int, err error) {
+	step = 1
+	rangeAndStep := strings.Split(expr, "/")
+	if len(rangeAndStep) > 2 {
+		return 0, 0, 0, fmt.Errorf("too many slashes in %q", expr)
+	}
+
+	switch r := rangeAndStep[0]; {
+	case r == "*" || r == "?":
+		lo, hi = b.min, b.max
+	case strings.ContainsAny(r, "LW#"):
+		return 0, 0, 0, fmt.Errorf("%q is not supported", expr)
+	default:
+		bounds := strings.Split(r, "-")
+		if len(bounds) > 2 {
+			return 0, 0, 0, fmt.Errorf("too many hyphens in %q", expr)
+		}
+		if lo, err = parseValue(bounds[0], b); err != nil {
+			return 0, 0, 0, err
+		}
+		hi = lo
+		if len(bounds) == 2 {
+			if hi, err = parseValue(bounds[1], b); err != nil {
+				return 0, 0, 0, err
+			}
+		} else if len(rangeAndStep) == 2 {
+			// Quartz style "a/n" runs from a to the upper bound
+			hi = b.max
+		}
+	}
+
+	if len(rangeAndStep) == 2 {
+		if step, err = strconv.Atoi(rangeAndStep[1]); err != nil || step <= 0 {
+			return 0, 0, 0, fmt.Errorf("invalid step in %q", expr)
+		}
+	}
+
+	if lo < b.min || hi > b.max || lo > hi {
+		return 0, 0, 0, fmt.Errorf("%q is out of range %d-%d", expr, b.min, b.max)
+	}
+	return lo, hi, step, nil
+}
+
+func parseValue(value string, b bounds) (int, error) {
+	if v, ok := b.names[strings.ToLower(value)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return v, nil
+}
+
+// Next returns the first activation time strictly after t,
+// the zero time is returned when the schedule never fires again
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+
+	yearLimit := yearBounds.max
+	if s.years == nil {
+		// a day and month combination like Feb 30 never matches
+		yearLimit = t.Year() + 5
+	}
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for s.years != nil && !s.years[t.Year()] {
+		t = time.Date(t.Year()+1, time.January, 1, 0, 0, 0, 0, loc)
+		if t.Year() > yearLimit {
+			return time.Time{}
+		}
+	}
+
+	for s.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+
+	for !s.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Truncate(time.Minute).Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+
+	for s.second&(1<<uint(t.Second())) == 0 {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto WRAP
+		}
+	}
+
+	return t
+}
+
+// dayMatches follows the cron convention: when both day fields are
+// restricted a day matching either of them is accepted
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,114 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	// a Monday
+	from := time.Date(2021, time.March, 1, 10, 15, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		from time.Time
+		next time.Time
+	}{
+		{"*/5 * * * *", from, time.Date(2021, time.March, 1, 10, 20, 0, 0, time.UTC)},
+		{"15 10 * * *", from, time.Date(2021, time.March, 2, 10, 15, 0, 0, time.UTC)},
+		{"0 0 1 1 *", from, time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2021, time.March, 6, 10, 0, 0, 0, time.UTC), time.Date(2021, time.March, 8, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", from, time.Date(2021, time.March, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", from, time.Date(2021, time.March, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", from, time.Date(2021, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * jun *", from, time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)},
+		// either restricted day field matches
+		{"0 0 13 * 5", from, time.Date(2021, time.March, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", from, time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// Quartz expressions
+		{"0 0 0 */1 * ?", from, time.Date(2021, time.March, 2, 0, 0, 0, 0, time.UTC)},
+		{"30 */10 * * * ?", from, time.Date(2021, time.March, 1, 10, 20, 30, 0, time.UTC)},
+		{"0 0 12 ? * SUN", from, time.Date(2021, time.March, 7, 12, 0, 0, 0, time.UTC)},
+		{"0 0 12 ? * 2", from, time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 15/20 * * * ?", from, time.Date(2021, time.March, 1, 10, 35, 0, 0, time.UTC)},
+		{"0 0 0 1 1 ? 2023", from, time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 0 1 1 ? *", from, time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		// never fires again
+		{"0 0 0 1 1 ? 2020", from, time.Time{}},
+		{"0 0 30 2 *", from, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next := s.Next(tt.from); !next.Equal(tt.next) {
+				t.Errorf("expected %s, got %s", tt.next, next)
+			}
+		})
+	}
+}
+
+func TestSchedule_NextIsStrictlyAfter(t *testing.T) {
+	s, err := Parse("*/5 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2021, time.March, 1, 10, 20, 0, 0, time.UTC)
+	if next := s.Next(from); !next.Equal(from.Add(5 * time.Minute)) {
+		t.Errorf("expected %s, got %s", from.Add(5*time.Minute), next)
+	}
+	if next := s.Next(from.Add(-time.Nanosecond)); !next.Equal(from) {
+		t.Errorf("expected %s, got %s", from, next)
+	}
+}
+
+func TestSchedule_NextKeepsLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*60*60)
+	s, err := Parse("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := s.Next(time.Date(2021, time.March, 1, 10, 0, 0, 0, loc))
+	if expected := time.Date(2021, time.March, 2, 9, 0, 0, 0, loc); !next.Equal(expected) || next.Location() != loc {
+		t.Errorf("expected %s, got %s", expected, next)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		spec string
+		err  string
+	}{
+		{"* *", "expected 5, 6 or 7 fields"},
+		{"* * * * * * * *", "expected 5, 6 or 7 fields"},
+		{"60 * * * *", "invalid minute field"},
+		{"* 24 * * *", "invalid hour field"},
+		{"* * 0 * *", "invalid day-of-month field"},
+		{"* * * 13 *", "invalid month field"},
+		{"* * * * 8", "invalid day-of-week field"},
+		{"0 * * * * 0", "invalid day-of-week field"},
+		{"60 * * * * ?", "invalid second field"},
+		{"0 0 0 ? * ?", "'?' can not be used for both"},
+		{"0 0 0 L * ?", "not supported"},
+		{"0 0 0 ? * 6#3", "not supported"},
+		{"*/0 * * * *", "invalid step"},
+		{"*/2/3 * * * *", "too many slashes"},
+		{"1-2-3 * * * *", "too many hyphens"},
+		{"5-1 * * * *", "out of range"},
+		{"* * * * foo", "invalid value"},
+		{"0 0 0 1 1 ? 1969", "invalid year field"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := Parse(tt.spec)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
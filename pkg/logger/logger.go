@@ -10,21 +10,31 @@ func NewLogger(loglevel string) (*zap.SugaredLogger, error) {
 }
 
 func NewLoggerWithEncoding(loglevel, zapEncoding string) (*zap.SugaredLogger, error) {
-	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	logger, _, err := NewLoggerWithLevel(loglevel, zapEncoding)
+	return logger, err
+}
+
+// ParseLevel returns the zap level of a log level name, unknown names are mapped to info
+func ParseLevel(loglevel string) zapcore.Level {
 	switch loglevel {
 	case "debug":
-		level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
-	case "info":
-		level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
-	case "warn":
-		level = zap.NewAtomicLevelAt(zapcore.WarnLevel)
+		return zapcore.DebugLevel
+	case "warn", "warning":
+		return zapcore.WarnLevel
 	case "error":
-		level = zap.NewAtomicLevelAt(zapcore.ErrorLevel)
+		return zapcore.ErrorLevel
 	case "fatal":
-		level = zap.NewAtomicLevelAt(zapcore.FatalLevel)
+		return zapcore.FatalLevel
 	case "panic":
-		level = zap.NewAtomicLevelAt(zapcore.PanicLevel)
+		return zapcore.PanicLevel
+	default:
+		return zapcore.InfoLevel
 	}
+}
+
+// NewLoggerWithLevel also returns the level of the logger so that it can be changed at runtime
+func NewLoggerWithLevel(loglevel, zapEncoding string) (*zap.SugaredLogger, zap.AtomicLevel, error) {
+	level := zap.NewAtomicLevelAt(ParseLevel(loglevel))
 	zapEncoderConfig := zapcore.EncoderConfig{
 		MessageKey:     "msg",
 		LevelKey:       "level",
@@ -52,7 +62,7 @@ func NewLoggerWithEncoding(loglevel, zapEncoding string) (*zap.SugaredLogger, er
 	}
 	logger, err := zapConfig.Build()
 	if err != nil {
-		return nil, err
+		return nil, level, err
 	}
-	return logger.Sugar(), nil
+	return logger.Sugar(), level, nil
 }
\ No newline at end of file
@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"time"
+)
+
+var canaryPhases = []examplev1beta1.CanaryPhase{
+	examplev1beta1.CanaryPhaseInitializing,
+	examplev1beta1.CanaryPhaseProgressing,
+	examplev1beta1.CanaryPhaseSucceeded,
+	examplev1beta1.CanaryPhaseFailed,
+}
+
+// Recorder records the controller and canary metrics
+type Recorder struct {
+	phase             *prometheus.GaugeVec
+	replicas          *prometheus.GaugeVec
+	reconciles        *prometheus.CounterVec
+	reconcileErrors   *prometheus.CounterVec
+	reconcileDuration *prometheus.HistogramVec
+	notifications     *prometheus.CounterVec
+	droppedMessages   *prometheus.CounterVec
+}
+
+// NewRecorder creates the metrics, they are registered with the default
+// Prometheus registry when register is true
+func NewRecorder(controller string, register bool) Recorder {
+	phase := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: controller,
+		Name:      "canary_phase",
+		Help:      "Canary phase, the gauge of the current phase is set to 1 and the others to 0",
+	}, []string{"name", "namespace", "phase"})
+
+	replicas := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: controller,
+		Name:      "canary_replicas",
+		Help:      "Desired replicas of the canary deployment",
+	}, []string{"name", "namespace"})
+
+	reconciles := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: controller,
+		Name:      "reconcile_total",
+		Help:      "Total number of canary reconciles",
+	}, []string{"name", "namespace"})
+
+	reconcileErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: controller,
+		Name:      "reconcile_errors_total",
+		Help:      "Total number of canary reconciles that returned an error",
+	}, []string{"name", "namespace"})
+
+	reconcileDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: controller,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Seconds spent reconciling a canary",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"name", "namespace"})
+
+	notifications := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: controller,
+		Name:      "notifications_total",
+		Help:      "Total number of notifications by provider and status",
+	}, []string{"provider", "status"})
+
+	droppedMessages := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: controller,
+		Name:      "notifications_dropped_total",
+		Help:      "Total number of notifications dropped by the overflow policy of the queue or by the rate limits",
+	}, []string{"policy"})
+
+	if register {
+		prometheus.MustRegister(phase)
+		prometheus.MustRegister(replicas)
+		prometheus.MustRegister(reconciles)
+		prometheus.MustRegister(reconcileErrors)
+		prometheus.MustRegister(reconcileDuration)
+		prometheus.MustRegister(notifications)
+		prometheus.MustRegister(droppedMessages)
+	}
+
+	return Recorder{
+		phase:             phase,
+		replicas:          replicas,
+		reconciles:        reconciles,
+		reconcileErrors:   reconcileErrors,
+		reconcileDuration: reconcileDuration,
+		notifications:     notifications,
+		droppedMessages:   droppedMessages,
+	}
+}
+
+// SetStatus sets the phase and replicas gauges of a canary
+func (cr Recorder) SetStatus(cd *examplev1beta1.Canary, phase examplev1beta1.CanaryPhase) {
+	for _, p := range canaryPhases {
+		value := 0.0
+		if p == phase {
+			value = 1
+		}
+		cr.phase.WithLabelValues(cd.Name, cd.Namespace, string(p)).Set(value)
+	}
+	cr.replicas.WithLabelValues(cd.Name, cd.Namespace).Set(float64(cd.Spec.Replicas))
+}
+
+// IncReconcile counts a reconcile and its duration, errors are counted separately
+func (cr Recorder) IncReconcile(cd *examplev1beta1.Canary, duration time.Duration, err error) {
+	cr.reconciles.WithLabelValues(cd.Name, cd.Namespace).Inc()
+	cr.reconcileDuration.WithLabelValues(cd.Name, cd.Namespace).Observe(duration.Seconds())
+	if err != nil {
+		cr.reconcileErrors.WithLabelValues(cd.Name, cd.Namespace).Inc()
+	}
+}
+
+// IncNotification counts a notification sent through a provider
+func (cr Recorder) IncNotification(provider string, err error) {
+	status := "sent"
+	if err != nil {
+		status = "failed"
+	}
+	cr.notifications.WithLabelValues(provider, status).Inc()
+}
+
+// IncDroppedNotification counts a notification dropped by the overflow policy or the rate limits
+func (cr Recorder) IncDroppedNotification(policy string) {
+	cr.droppedMessages.WithLabelValues(policy).Inc()
+}
+
+// DeleteCanary removes the series of a deleted canary
+func (cr Recorder) DeleteCanary(name, namespace string) {
+	for _, p := range canaryPhases {
+		cr.phase.DeleteLabelValues(name, namespace, string(p))
+	}
+	cr.replicas.DeleteLabelValues(name, namespace)
+	cr.reconciles.DeleteLabelValues(name, namespace)
+	cr.reconcileErrors.DeleteLabelValues(name, namespace)
+	cr.reconcileDuration.DeleteLabelValues(name, namespace)
+}
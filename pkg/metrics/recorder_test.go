@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"testing"
+	"time"
+)
+
+func newTestCanary(replicas int32) *examplev1beta1.Canary {
+	return &examplev1beta1.Canary{
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "test"},
+		Spec:       examplev1beta1.CanarySpec{Replicas: replicas},
+	}
+}
+
+func TestRecorder_SetStatus(t *testing.T) {
+	r := NewRecorder("test", false)
+
+	r.SetStatus(newTestCanary(3), examplev1beta1.CanaryPhaseProgressing)
+	r.SetStatus(newTestCanary(4), examplev1beta1.CanaryPhaseSucceeded)
+
+	// only the gauge of the current phase is set
+	for _, phase := range canaryPhases {
+		expected := 0.0
+		if phase == examplev1beta1.CanaryPhaseSucceeded {
+			expected = 1
+		}
+		if got := testutil.ToFloat64(r.phase.WithLabelValues("podinfo", "test", string(phase))); got != expected {
+			t.Errorf("expected the %s gauge to be %v, got %v", phase, expected, got)
+		}
+	}
+	if got := testutil.ToFloat64(r.replicas.WithLabelValues("podinfo", "test")); got != 4 {
+		t.Errorf("expected 4 replicas, got %v", got)
+	}
+}
+
+func TestRecorder_IncReconcile(t *testing.T) {
+	r := NewRecorder("test", false)
+	cd := newTestCanary(1)
+
+	r.IncReconcile(cd, 100*time.Millisecond, nil)
+	r.IncReconcile(cd, 2*time.Second, errors.New("conflict"))
+
+	if got := testutil.ToFloat64(r.reconciles.WithLabelValues("podinfo", "test")); got != 2 {
+		t.Errorf("expected 2 reconciles, got %v", got)
+	}
+	if got := testutil.ToFloat64(r.reconcileErrors.WithLabelValues("podinfo", "test")); got != 1 {
+		t.Errorf("expected 1 reconcile error, got %v", got)
+	}
+	if got := testutil.CollectAndCount(r.reconcileDuration); got != 1 {
+		t.Fatalf("expected a histogram per canary, got %d", got)
+	}
+	if got := histogramCount(t, r.reconcileDuration); got != 2 {
+		t.Errorf("expected 2 observed durations, got %d", got)
+	}
+}
+
+func TestRecorder_Notifications(t *testing.T) {
+	r := NewRecorder("test", false)
+
+	r.IncNotification("slack", nil)
+	r.IncNotification("slack", errors.New("unavailable"))
+	r.IncNotification("slack", nil)
+	r.IncDroppedNotification("drop-newest")
+
+	if got := testutil.ToFloat64(r.notifications.WithLabelValues("slack", "sent")); got != 2 {
+		t.Errorf("expected 2 sent notifications, got %v", got)
+	}
+	if got := testutil.ToFloat64(r.notifications.WithLabelValues("slack", "failed")); got != 1 {
+		t.Errorf("expected 1 failed notification, got %v", got)
+	}
+	if got := testutil.ToFloat64(r.droppedMessages.WithLabelValues("drop-newest")); got != 1 {
+		t.Errorf("expected 1 dropped notification, got %v", got)
+	}
+}
+
+func TestRecorder_DeleteCanary(t *testing.T) {
+	r := NewRecorder("test", false)
+	cd := newTestCanary(2)
+	other := newTestCanary(2)
+	other.Name = "other"
+
+	for _, c := range []*examplev1beta1.Canary{cd, other} {
+		r.SetStatus(c, examplev1beta1.CanaryPhaseProgressing)
+		r.IncReconcile(c, time.Second, errors.New("conflict"))
+	}
+	r.DeleteCanary("podinfo", "test")
+
+	// the series of the other canary are kept
+	collectors := map[string]prometheus.Collector{
+		"phase":              r.phase,
+		"replicas":           r.replicas,
+		"reconciles":         r.reconciles,
+		"reconcile errors":   r.reconcileErrors,
+		"reconcile duration": r.reconcileDuration,
+	}
+	expected := map[string]int{
+		"phase":              len(canaryPhases),
+		"replicas":           1,
+		"reconciles":         1,
+		"reconcile errors":   1,
+		"reconcile duration": 1,
+	}
+	for name, collector := range collectors {
+		if got := testutil.CollectAndCount(collector); got != expected[name] {
+			t.Errorf("expected %d %s series, got %d", expected[name], name, got)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	// a second call does not register the metrics twice
+	Register()
+	Register()
+
+	queue := workqueue.NewNamed("register-test")
+	defer queue.ShutDown()
+	queue.Add("test/podinfo")
+
+	if got := testutil.ToFloat64(workqueueAdds.WithLabelValues("register-test")); got != 1 {
+		t.Errorf("expected the work queue to report its adds, got %v", got)
+	}
+	if got := testutil.ToFloat64(workqueueDepth.WithLabelValues("register-test")); got != 1 {
+		t.Errorf("expected the work queue to report its depth, got %v", got)
+	}
+}
+
+// histogramCount returns the number of observations of the single series of the histogram
+func histogramCount(t *testing.T, histogram *prometheus.HistogramVec) uint64 {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(histogram)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return families[0].GetMetric()[0].GetHistogram().GetSampleCount()
+}
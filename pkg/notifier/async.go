@@ -0,0 +1,154 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/metrics"
+	"sync"
+	"time"
+)
+
+// Overflow policies applied when the queue of an Async dispatcher is full
+const (
+	// OverflowDropNewest rejects the incoming notification
+	OverflowDropNewest = "drop-newest"
+	// OverflowDropOldest evicts the oldest queued notification
+	OverflowDropOldest = "drop-oldest"
+	// OverflowBlock makes Post wait for room up to the block timeout
+	OverflowBlock = "block"
+)
+
+var (
+	ErrQueueFull = errors.New("notification queue is full")
+	ErrStopped   = errors.New("notification dispatcher is stopped")
+	ErrEvicted   = errors.New("notification evicted from the full queue by a newer one")
+)
+
+type message struct {
+	notifier  Interface
+	workload  string
+	namespace string
+	message   string
+	fields    []Field
+	severity  string
+}
+
+// Async queues notifications and posts them from a pool of workers
+// so that callers never wait on the providers
+type Async struct {
+	notifier     Interface
+	workers      int
+	overflow     string
+	blockTimeout time.Duration
+	onError      func(workload, namespace string, err error)
+	recorder     metrics.Recorder
+
+	mu      sync.RWMutex
+	stopped bool
+	queue   chan message
+}
+
+// NewAsync creates a dispatcher, onError receives the errors of the queued notifications
+// and of those evicted by the drop-oldest policy, every dropped notification is counted
+func NewAsync(notifier Interface, workers, queueSize int, overflow string, blockTimeout time.Duration,
+	onError func(workload, namespace string, err error), recorder metrics.Recorder) (*Async, error) {
+	switch overflow {
+	case OverflowDropNewest, OverflowDropOldest, OverflowBlock:
+	default:
+		return nil, fmt.Errorf("overflow policy %s not supported", overflow)
+	}
+	if workers < 1 {
+		return nil, fmt.Errorf("at least one notification worker is required")
+	}
+
+	return &Async{
+		notifier:     notifier,
+		workers:      workers,
+		overflow:     overflow,
+		blockTimeout: blockTimeout,
+		onError:      onError,
+		recorder:     recorder,
+		queue:        make(chan message, queueSize),
+	}, nil
+}
+
+// Post queues the notification, the error reports whether it was dropped
+func (a *Async) Post(workload string, namespace string, text string, fields []Field, severity string) error {
+	return a.PostTo(nil, workload, namespace, text, fields, severity)
+}
+
+// PostTo queues the notification along with the notifier of the workload
+func (a *Async) PostTo(notifier Interface, workload string, namespace string, text string, fields []Field, severity string) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.stopped {
+		return ErrStopped
+	}
+
+	msg := message{notifier, workload, namespace, text, fields, severity}
+	select {
+	case a.queue <- msg:
+		return nil
+	default:
+	}
+
+	switch a.overflow {
+	case OverflowDropOldest:
+		select {
+		case evicted := <-a.queue:
+			a.recorder.IncDroppedNotification(a.overflow)
+			if a.onError != nil {
+				a.onError(evicted.workload, evicted.namespace, ErrEvicted)
+			}
+		default:
+		}
+		select {
+		case a.queue <- msg:
+			return nil
+		default:
+			a.recorder.IncDroppedNotification(a.overflow)
+			return ErrQueueFull
+		}
+	case OverflowBlock:
+		timer := time.NewTimer(a.blockTimeout)
+		defer timer.Stop()
+		select {
+		case a.queue <- msg:
+			return nil
+		case <-timer.C:
+			a.recorder.IncDroppedNotification(a.overflow)
+			return ErrQueueFull
+		}
+	default:
+		a.recorder.IncDroppedNotification(a.overflow)
+		return ErrQueueFull
+	}
+}
+
+// Run starts the workers and blocks until the stop channel is closed
+// and every queued notification has been posted
+func (a *Async) Run(stopCh <-chan struct{}) {
+	var wg sync.WaitGroup
+	for i := 0; i < a.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range a.queue {
+				err := PostTo(a.notifier, msg.notifier, msg.workload, msg.namespace, msg.message, msg.fields, msg.severity)
+				if err != nil && a.onError != nil {
+					a.onError(msg.workload, msg.namespace, err)
+				}
+			}
+		}()
+	}
+
+	<-stopCh
+
+	// reject new notifications and let the workers drain the queue
+	a.mu.Lock()
+	a.stopped = true
+	close(a.queue)
+	a.mu.Unlock()
+
+	wg.Wait()
+}
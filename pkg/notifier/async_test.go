@@ -0,0 +1,189 @@
+package notifier
+
+import (
+	"errors"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/metrics"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier records the posted messages and fails those listed in errs
+type recordingNotifier struct {
+	mu       sync.Mutex
+	messages []string
+	errs     map[string]error
+}
+
+func (r *recordingNotifier) Post(workload string, namespace string, message string, fields []Field, severity string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, message)
+	return r.errs[message]
+}
+
+func (r *recordingNotifier) posted() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.messages...)
+}
+
+// errorRecorder collects the errors passed to onError
+type errorRecorder struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (e *errorRecorder) onError(workload, namespace string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errs = append(e.errs, err)
+}
+
+func (e *errorRecorder) list() []error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]error{}, e.errs...)
+}
+
+func newTestAsync(t *testing.T, n Interface, queueSize int, overflow string, onError func(workload, namespace string, err error)) *Async {
+	a, err := NewAsync(n, 1, queueSize, overflow, 20*time.Millisecond, onError, metrics.NewRecorder("test", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// drain runs the workers until every queued message is posted
+func drain(a *Async) {
+	stopCh := make(chan struct{})
+	close(stopCh)
+	a.Run(stopCh)
+}
+
+func TestAsync_PostsQueuedMessages(t *testing.T) {
+	n := &recordingNotifier{errs: map[string]error{"b": errors.New("provider down")}}
+	errs := &errorRecorder{}
+	a := newTestAsync(t, n, 10, OverflowDropNewest, errs.onError)
+
+	for _, msg := range []string{"a", "b", "c"} {
+		if err := a.Post("podinfo", "test", msg, nil, SeverityInfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drain(a)
+
+	if posted := n.posted(); len(posted) != 3 {
+		t.Errorf("expected the queue to be drained on stop, got %v", posted)
+	}
+	if list := errs.list(); len(list) != 1 || list[0].Error() != "provider down" {
+		t.Errorf("expected the provider error to be reported, got %v", list)
+	}
+	if err := a.Post("podinfo", "test", "d", nil, SeverityInfo); !errors.Is(err, ErrStopped) {
+		t.Errorf("expected ErrStopped after the stop, got %v", err)
+	}
+}
+
+func TestAsync_DropNewest(t *testing.T) {
+	n := &recordingNotifier{}
+	errs := &errorRecorder{}
+	a := newTestAsync(t, n, 2, OverflowDropNewest, errs.onError)
+
+	a.Post("podinfo", "test", "a", nil, SeverityInfo)
+	a.Post("podinfo", "test", "b", nil, SeverityInfo)
+	if err := a.Post("podinfo", "test", "c", nil, SeverityInfo); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+	drain(a)
+
+	if posted := n.posted(); len(posted) != 2 || posted[0] != "a" || posted[1] != "b" {
+		t.Errorf("expected the newest message to be dropped, got %v", posted)
+	}
+	if list := errs.list(); len(list) != 0 {
+		t.Errorf("expected the caller to get the error instead of onError, got %v", list)
+	}
+}
+
+func TestAsync_DropOldest(t *testing.T) {
+	n := &recordingNotifier{}
+	errs := &errorRecorder{}
+	a := newTestAsync(t, n, 2, OverflowDropOldest, errs.onError)
+
+	a.Post("podinfo", "test", "a", nil, SeverityInfo)
+	a.Post("podinfo", "test", "b", nil, SeverityInfo)
+	if err := a.Post("podinfo", "test", "c", nil, SeverityInfo); err != nil {
+		t.Errorf("expected the newest message to be queued, got %v", err)
+	}
+	drain(a)
+
+	if posted := n.posted(); len(posted) != 2 || posted[0] != "b" || posted[1] != "c" {
+		t.Errorf("expected the oldest message to be dropped, got %v", posted)
+	}
+	if list := errs.list(); len(list) != 1 || !errors.Is(list[0], ErrEvicted) {
+		t.Errorf("expected the evicted message to be reported, got %v", list)
+	}
+}
+
+func TestAsync_BlockTimesOut(t *testing.T) {
+	n := &recordingNotifier{}
+	a := newTestAsync(t, n, 1, OverflowBlock, nil)
+
+	a.Post("podinfo", "test", "a", nil, SeverityInfo)
+	start := time.Now()
+	if err := a.Post("podinfo", "test", "b", nil, SeverityInfo); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull after the block timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected Post to wait for the block timeout, waited %s", elapsed)
+	}
+	drain(a)
+}
+
+func TestAsync_BlockWaitsForRoom(t *testing.T) {
+	release := make(chan struct{})
+	n := &blockingNotifier{release: release}
+	a, err := NewAsync(n, 1, 1, OverflowBlock, time.Second, nil, metrics.NewRecorder("test", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		a.Run(stopCh)
+		close(done)
+	}()
+
+	// the worker holds the first message and the second one fills the queue
+	a.Post("podinfo", "test", "a", nil, SeverityInfo)
+	a.Post("podinfo", "test", "b", nil, SeverityInfo)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	if err := a.Post("podinfo", "test", "c", nil, SeverityInfo); err != nil {
+		t.Errorf("expected the message to be queued once the worker made room, got %v", err)
+	}
+
+	close(stopCh)
+	<-done
+}
+
+// blockingNotifier waits for the release of every post
+type blockingNotifier struct {
+	release chan struct{}
+}
+
+func (b *blockingNotifier) Post(workload string, namespace string, message string, fields []Field, severity string) error {
+	<-b.release
+	return nil
+}
+
+func TestNewAsync_Invalid(t *testing.T) {
+	recorder := metrics.NewRecorder("test", false)
+	if _, err := NewAsync(&NopNotifier{}, 1, 1, "drop-all", time.Second, nil, recorder); err == nil {
+		t.Error("expected an error for an unknown overflow policy")
+	}
+	if _, err := NewAsync(&NopNotifier{}, 0, 1, OverflowBlock, time.Second, nil, recorder); err == nil {
+		t.Error("expected an error without workers")
+	}
+}
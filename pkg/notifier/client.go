@@ -6,38 +6,207 @@ import (
 	"fmt"
 	"io/ioutil"
 	"k8s.io/apimachinery/pkg/util/json"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
-func postMessage(address string, payload interface{}) error {
+// RetryPolicy configures how failed notifications are retried
+type RetryPolicy struct {
+	// Retries is the number of attempts made after the first one
+	Retries int
+	// MinBackoff is the wait before the first retry, it doubles on every attempt
+	MinBackoff time.Duration
+	// MaxBackoff caps the backoff and the Retry-After header
+	MaxBackoff time.Duration
+	// Timeout bounds each attempt
+	Timeout time.Duration
+}
+
+// DefaultRetryPolicy is used by the clients created without a policy
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Retries:    3,
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+		Timeout:    5 * time.Second,
+	}
+}
+
+// Client posts the payloads of the providers with the retry policy and moves
+// those that could not be delivered to the dead letter queue
+type Client struct {
+	ctx         context.Context
+	retry       RetryPolicy
+	deadLetters *DeadLetterQueue
+	httpClient  *http.Client
+}
+
+// NewClient creates the client shared by the providers, cancelling ctx stops the
+// backoff between the attempts so that a shutdown does not wait for the retries,
+// the dead letter queue may be nil
+func NewClient(ctx context.Context, retry RetryPolicy, deadLetters *DeadLetterQueue) *Client {
+	return &Client{
+		ctx:         ctx,
+		retry:       retry,
+		deadLetters: deadLetters,
+		httpClient:  &http.Client{},
+	}
+}
+
+// DeadLetters returns the queue of the notifications that could not be delivered
+func (c *Client) DeadLetters() *DeadLetterQueue {
+	return c.deadLetters
+}
+
+// defaultClient is used by the providers created without a client
+var defaultClient = NewClient(context.Background(), DefaultRetryPolicy(), nil)
+
+// clientOrDefault returns the default client when client is nil
+func clientOrDefault(client *Client) *Client {
+	if client == nil {
+		return defaultClient
+	}
+	return client
+}
+
+// PostJSON posts the payload as JSON with the retry policy of the client
+func (c *Client) PostJSON(address string, payload interface{}) error {
+	return c.postMessage(address, payload)
+}
+
+func (c *Client) postMessage(address string, payload interface{}) error {
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("marshalling notification payload failed: %w", err)
 	}
 
-	b := bytes.NewBuffer(data)
+	return c.postData(address, data)
+}
 
-	req, err := http.NewRequest("post", address, b)
-	if err != nil {
-		return fmt.Errorf("http.NewRequest failed: %w", err)
+// postData sends the payload with retries, the payload is moved to the
+// dead letter queue when it could not be delivered
+func (c *Client) postData(address string, data []byte) error {
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		var retryAfter time.Duration
+		var retryable bool
+		retryAfter, retryable, err = c.postOnce(address, data)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempts > c.retry.Retries {
+			break
+		}
+
+		wait := c.backoff(attempts)
+		if retryAfter > 0 {
+			wait = retryAfter
+			if wait > c.retry.MaxBackoff {
+				wait = c.retry.MaxBackoff
+			}
+		}
+		if !c.sleep(wait) {
+			// the remaining attempts are given up on shutdown
+			break
+		}
 	}
-	req.Header.Set("Content-type", "application/json")
 
-	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+	c.deadLetters.Add(address, data, attempts, err)
+	return err
+}
+
+// sleep waits for the backoff, false is returned when the context of the client is done first
+func (c *Client) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// postOnce makes a single attempt, it returns whether the error is worth
+// retrying and the delay requested by the server if any, the attempt is not
+// bound to the context of the client so that the queued notifications are
+// still sent once on shutdown
+func (c *Client) postOnce(address string, data []byte) (time.Duration, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.retry.Timeout)
 	defer cancel()
 
-	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, address, bytes.NewBuffer(data))
 	if err != nil {
-		return fmt.Errorf("sending notification failed: %w", err)
+		return 0, false, fmt.Errorf("http.NewRequest failed: %w", err)
+	}
+	req.Header.Set("Content-type", "application/json")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		// url.Error embeds the full hook URL, keep only the host
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return 0, true, fmt.Errorf("sending notification to %s failed: %w", hostOf(address), err)
 	}
 
 	defer res.Body.Close()
 	statusCode := res.StatusCode
-	if statusCode != 200 {
-		body, _ := ioutil.ReadAll(res.Body)
-		return fmt.Errorf("sending notification failed: %s", string(body))
+	if statusCode >= 200 && statusCode < 300 {
+		return 0, false, nil
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	err = fmt.Errorf("sending notification failed with status %d: %s", statusCode, string(body))
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return parseRetryAfter(res.Header.Get("Retry-After")), true, err
+	case statusCode >= 500:
+		return 0, true, err
+	default:
+		return 0, false, err
+	}
+}
+
+// backoff doubles the minimum backoff on every attempt and adds up to 50% of jitter
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.retry.MinBackoff << uint(attempt-1)
+	if d <= 0 || d > c.retry.MaxBackoff {
+		d = c.retry.MaxBackoff
+	}
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
 	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
 
-	return nil
+// parseRetryAfter accepts both delay seconds and an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// hostOf returns the host of a hook URL, the rest of the URL usually holds the secret token
+func hostOf(address string) string {
+	u, err := url.Parse(address)
+	if err != nil {
+		return ""
+	}
+	return u.Host
 }
@@ -0,0 +1,180 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"gopkg.in/h2non/gock.v1"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withRetryPolicy returns a client with a fast retry policy and an empty dead letter queue
+func withRetryPolicy(t *testing.T, retries int) *Client {
+	t.Cleanup(gock.Off)
+	return NewClient(context.Background(),
+		RetryPolicy{Retries: retries, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, Timeout: time.Second},
+		NewDeadLetterQueue(10))
+}
+
+func TestPostData_RetriesServerErrors(t *testing.T) {
+	client := withRetryPolicy(t, 3)
+
+	gock.New("https://hooks.example.com").Post("/token").Times(2).Reply(503)
+	gock.New("https://hooks.example.com").Post("/token").Reply(200)
+
+	if err := client.postData("https://hooks.example.com/token", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if !gock.IsDone() {
+		t.Error("expected two failed attempts and a successful one")
+	}
+	if letters := client.deadLetters.List(); len(letters) != 0 {
+		t.Errorf("expected no dead letter, got %+v", letters)
+	}
+}
+
+func TestPostData_DoesNotRetryClientErrors(t *testing.T) {
+	client := withRetryPolicy(t, 3)
+
+	gock.New("https://hooks.example.com").Post("/token").Reply(400).BodyString("invalid payload")
+	gock.New("https://hooks.example.com").Post("/token").Reply(200)
+
+	err := client.postData("https://hooks.example.com/token", []byte(`{"text":"hi"}`))
+	if err == nil || !strings.Contains(err.Error(), "status 400: invalid payload") {
+		t.Fatalf("expected a status 400 error, got %v", err)
+	}
+	if len(gock.Pending()) != 1 {
+		t.Error("expected a single attempt")
+	}
+
+	letters := client.deadLetters.List()
+	if len(letters) != 1 {
+		t.Fatalf("expected a dead letter, got %+v", letters)
+	}
+	if letters[0].Host != "hooks.example.com" || letters[0].Attempts != 1 || string(letters[0].Payload) != `{"text":"hi"}` {
+		t.Errorf("unexpected dead letter %+v", letters[0])
+	}
+}
+
+func TestPostData_GivesUpAfterRetries(t *testing.T) {
+	client := withRetryPolicy(t, 2)
+
+	gock.New("https://hooks.example.com").Post("/token").Times(3).Reply(500)
+
+	if err := client.postData("https://hooks.example.com/token", []byte(`{}`)); err == nil {
+		t.Fatal("expected an error once the retries are exhausted")
+	}
+	if !gock.IsDone() {
+		t.Error("expected the first attempt and two retries")
+	}
+	if letters := client.deadLetters.List(); len(letters) != 1 || letters[0].Attempts != 3 {
+		t.Errorf("expected a dead letter after 3 attempts, got %+v", letters)
+	}
+}
+
+func TestPostData_RetryAfterIsCapped(t *testing.T) {
+	client := withRetryPolicy(t, 1)
+
+	gock.New("https://hooks.example.com").Post("/token").Reply(429).SetHeader("Retry-After", "60")
+	gock.New("https://hooks.example.com").Post("/token").Reply(200)
+
+	start := time.Now()
+	if err := client.postData("https://hooks.example.com/token", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the Retry-After delay to be capped by the max backoff, waited %s", elapsed)
+	}
+	if !gock.IsDone() {
+		t.Error("expected the throttled attempt to be retried")
+	}
+}
+
+func TestPostData_StopsRetryingOnShutdown(t *testing.T) {
+	t.Cleanup(gock.Off)
+	ctx, cancel := context.WithCancel(context.Background())
+	client := NewClient(ctx, RetryPolicy{Retries: 3, MinBackoff: time.Minute, MaxBackoff: time.Minute, Timeout: time.Second},
+		NewDeadLetterQueue(10))
+
+	gock.New("https://hooks.example.com").Post("/token").Times(4).Reply(503)
+
+	// the backoff is interrupted, the notification is not retried
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	if err := client.postData("https://hooks.example.com/token", []byte(`{}`)); err == nil {
+		t.Fatal("expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the backoff to be cancelled, waited %s", elapsed)
+	}
+	if letters := client.deadLetters.List(); len(letters) != 1 || letters[0].Attempts != 1 {
+		t.Errorf("expected a dead letter after a single attempt, got %+v", letters)
+	}
+}
+
+func TestPostData_ErrorHidesHookURL(t *testing.T) {
+	client := withRetryPolicy(t, 0)
+
+	gock.New("https://hooks.example.com").Post("/secret-token").ReplyError(errors.New("connection refused"))
+
+	err := client.postData("https://hooks.example.com/secret-token", []byte(`{}`))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if strings.Contains(err.Error(), "secret-token") || !strings.Contains(err.Error(), "hooks.example.com") {
+		t.Errorf("expected the error to only name the host, got %v", err)
+	}
+	if letters := client.deadLetters.List(); len(letters) != 1 || strings.Contains(letters[0].Error, "secret-token") {
+		t.Errorf("expected the dead letter to hide the hook URL, got %+v", letters)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	client := NewClient(context.Background(), RetryPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}, nil)
+
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second},
+		// the shift overflows, the max backoff applies
+		{80, 500 * time.Millisecond, time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := client.backoff(tt.attempt); d < tt.min || d > tt.max {
+				t.Errorf("backoff(%d) = %s, expected between %s and %s", tt.attempt, d, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter(""); d != 0 {
+		t.Errorf("expected 0 for an empty header, got %s", d)
+	}
+	if d := parseRetryAfter("120"); d != 2*time.Minute {
+		t.Errorf("expected 2m, got %s", d)
+	}
+	if d := parseRetryAfter("-1"); d != 0 {
+		t.Errorf("expected 0 for a negative delay, got %s", d)
+	}
+	if d := parseRetryAfter("soon"); d != 0 {
+		t.Errorf("expected 0 for an invalid header, got %s", d)
+	}
+
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if d := parseRetryAfter(date); d <= 50*time.Second || d > time.Minute {
+		t.Errorf("expected about 1m for %s, got %s", date, d)
+	}
+	past := time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)
+	if d := parseRetryAfter(past); d != 0 {
+		t.Errorf("expected 0 for a date in the past, got %s", d)
+	}
+}
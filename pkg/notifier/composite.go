@@ -0,0 +1,63 @@
+package notifier
+
+import (
+	"fmt"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sync"
+)
+
+var severityRanks = map[string]int{
+	SeverityInfo:  0,
+	SeverityWarn:  1,
+	SeverityError: 2,
+}
+
+// IsValidSeverity returns true for the severities accepted by Route.MinSeverity
+func IsValidSeverity(severity string) bool {
+	_, ok := severityRanks[severity]
+	return ok
+}
+
+// Route sends the notifications of at least MinSeverity to a notifier
+type Route struct {
+	Provider    string
+	Notifier    Interface
+	MinSeverity string
+}
+
+// Composite fans out notifications to several providers
+type Composite struct {
+	Routes []Route
+}
+
+func NewComposite(routes ...Route) *Composite {
+	return &Composite{
+		Routes: routes,
+	}
+}
+
+// Post sends the message to every matching route concurrently and
+// returns the errors of all the providers that failed
+func (c *Composite) Post(workload string, namespace string, message string, fields []Field, severity string) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+
+	for _, route := range c.Routes {
+		if severityRanks[severity] < severityRanks[route.MinSeverity] {
+			continue
+		}
+		wg.Add(1)
+		go func(route Route) {
+			defer wg.Done()
+			if err := route.Notifier.Post(workload, namespace, message, fields, severity); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", route.Provider, err))
+				mu.Unlock()
+			}
+		}(route)
+	}
+	wg.Wait()
+
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,138 @@
+package notifier
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestComposite_SeverityFilter(t *testing.T) {
+	tests := []struct {
+		severity string
+		info     bool
+		warn     bool
+		error    bool
+	}{
+		{SeverityInfo, true, false, false},
+		{SeverityWarn, true, true, false},
+		{SeverityError, true, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.severity, func(t *testing.T) {
+			info, warn, errs := &recordingNotifier{}, &recordingNotifier{}, &recordingNotifier{}
+			c := NewComposite(
+				Route{Provider: "info", Notifier: info, MinSeverity: SeverityInfo},
+				Route{Provider: "warn", Notifier: warn, MinSeverity: SeverityWarn},
+				Route{Provider: "error", Notifier: errs, MinSeverity: SeverityError},
+			)
+
+			if err := c.Post("podinfo", "test", "message", nil, tt.severity); err != nil {
+				t.Fatal(err)
+			}
+			for _, route := range []struct {
+				name     string
+				notifier *recordingNotifier
+				posted   bool
+			}{
+				{"info", info, tt.info},
+				{"warn", warn, tt.warn},
+				{"error", errs, tt.error},
+			} {
+				if posted := len(route.notifier.posted()) == 1; posted != route.posted {
+					t.Errorf("expected the %s route to be posted to: %t, got %t", route.name, route.posted, posted)
+				}
+			}
+		})
+	}
+}
+
+// barrierNotifier returns once all the notifiers sharing the barrier are posting
+type barrierNotifier struct {
+	barrier *sync.WaitGroup
+}
+
+func (b *barrierNotifier) Post(workload string, namespace string, message string, fields []Field, severity string) error {
+	b.barrier.Done()
+	done := make(chan struct{})
+	go func() {
+		b.barrier.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(time.Second):
+		return errors.New("the other routes were not posted to concurrently")
+	}
+}
+
+func TestComposite_ConcurrentFanOut(t *testing.T) {
+	barrier := &sync.WaitGroup{}
+	barrier.Add(3)
+	c := NewComposite(
+		Route{Provider: "slack", Notifier: &barrierNotifier{barrier}, MinSeverity: SeverityInfo},
+		Route{Provider: "msteams", Notifier: &barrierNotifier{barrier}, MinSeverity: SeverityInfo},
+		Route{Provider: "discord", Notifier: &barrierNotifier{barrier}, MinSeverity: SeverityInfo},
+	)
+
+	// a sequential fan-out would block the first route until its timeout
+	if err := c.Post("podinfo", "test", "message", nil, SeverityInfo); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestComposite_Errors(t *testing.T) {
+	failing := map[string]error{"message": errors.New("unavailable")}
+	tests := []struct {
+		name   string
+		routes map[string]*recordingNotifier
+		errs   []string
+	}{
+		{
+			name:   "no failure",
+			routes: map[string]*recordingNotifier{"slack": {}, "msteams": {}},
+		},
+		{
+			name:   "one failure",
+			routes: map[string]*recordingNotifier{"slack": {errs: failing}, "msteams": {}},
+			errs:   []string{"slack: unavailable"},
+		},
+		{
+			name:   "all failures",
+			routes: map[string]*recordingNotifier{"slack": {errs: failing}, "msteams": {errs: failing}},
+			errs:   []string{"slack: unavailable", "msteams: unavailable"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var routes []Route
+			for provider, n := range tt.routes {
+				routes = append(routes, Route{Provider: provider, Notifier: n, MinSeverity: SeverityInfo})
+			}
+
+			err := NewComposite(routes...).Post("podinfo", "test", "message", nil, SeverityInfo)
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+			} else if err == nil {
+				t.Fatalf("expected the errors %v", tt.errs)
+			}
+			for _, expected := range tt.errs {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected the error to contain %q, got %v", expected, err)
+				}
+			}
+			// a failing provider does not keep the message from the others
+			for provider, n := range tt.routes {
+				if len(n.posted()) != 1 {
+					t.Errorf("expected %s to be posted to, got %v", provider, n.posted())
+				}
+			}
+		})
+	}
+}
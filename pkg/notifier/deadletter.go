@@ -0,0 +1,77 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DeadLetter is a notification dropped after the last delivery attempt
+type DeadLetter struct {
+	Time     time.Time       `json:"time"`
+	Host     string          `json:"host"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// DeadLetterQueue is a bounded in-memory buffer, the oldest
+// entries are evicted when the buffer is full
+type DeadLetterQueue struct {
+	mu      sync.Mutex
+	size    int
+	letters []DeadLetter
+}
+
+func NewDeadLetterQueue(size int) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		size: size,
+	}
+}
+
+// Add records a dropped notification, the hook URL is reduced to its host,
+// a nil queue drops it
+func (q *DeadLetterQueue) Add(address string, payload []byte, attempts int, err error) {
+	if q == nil || q.size <= 0 {
+		return
+	}
+
+	letter := DeadLetter{
+		Time:     time.Now(),
+		Host:     hostOf(address),
+		Attempts: attempts,
+		Payload:  json.RawMessage(payload),
+	}
+	if err != nil {
+		letter.Error = err.Error()
+	}
+	if !json.Valid(payload) {
+		letter.Payload, _ = json.Marshal(string(payload))
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.letters) >= q.size {
+		q.letters = q.letters[len(q.letters)-q.size+1:]
+	}
+	q.letters = append(q.letters, letter)
+}
+
+// List returns a copy of the dropped notifications, oldest first
+func (q *DeadLetterQueue) List() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]DeadLetter{}, q.letters...)
+}
+
+// ServeHTTP lists the dropped notifications as JSON
+func (q *DeadLetterQueue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(q.List())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
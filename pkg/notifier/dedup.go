@@ -0,0 +1,175 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/metrics"
+	"k8s.io/client-go/util/flowcontrol"
+	"sync"
+	"time"
+)
+
+// limiterIdleTimeout is the time after which the limiter of a canary is evicted,
+// the bucket of a per minute limit is full again by then so a new one is equivalent
+const limiterIdleTimeout = time.Minute
+
+// rateLimitPolicy labels the notifications dropped by the rate limits
+const rateLimitPolicy = "rate-limit"
+
+// ErrRateLimited reports a notification dropped by the rate limits when no window
+// summarizes the dropped notifications
+var ErrRateLimited = errors.New("notification dropped by the rate limit")
+
+// Deduplicator suppresses identical notifications of a canary within a window
+// and rate limits notifications per canary and globally, the number of suppressed
+// notifications is sent as a single summary when the window closes. The rate limited
+// notifications are counted, without a window they are reported to onError.
+type Deduplicator struct {
+	notifier    Interface
+	window      time.Duration
+	canaryLimit int
+	global      flowcontrol.RateLimiter
+	onError     func(workload, namespace string, err error)
+	recorder    metrics.Recorder
+
+	mu        sync.Mutex
+	entries   map[string]*dedupEntry
+	limiters  map[string]*canaryLimiter
+	lastPrune time.Time
+}
+
+type canaryLimiter struct {
+	limiter  flowcontrol.RateLimiter
+	lastUsed time.Time
+}
+
+type dedupEntry struct {
+	notifier   Interface
+	workload   string
+	namespace  string
+	message    string
+	fields     []Field
+	severity   string
+	suppressed int
+}
+
+// NewDeduplicator creates a deduplicator, the limits are notifications per minute
+// and a zero window or limit disables the matching check
+func NewDeduplicator(notifier Interface, window time.Duration, canaryLimit, globalLimit int,
+	onError func(workload, namespace string, err error), recorder metrics.Recorder) *Deduplicator {
+	d := &Deduplicator{
+		notifier:    notifier,
+		window:      window,
+		canaryLimit: canaryLimit,
+		onError:     onError,
+		recorder:    recorder,
+		entries:     map[string]*dedupEntry{},
+		limiters:    map[string]*canaryLimiter{},
+	}
+	if globalLimit > 0 {
+		d.global = newPerMinuteLimiter(globalLimit)
+	}
+	return d
+}
+
+func newPerMinuteLimiter(limit int) flowcontrol.RateLimiter {
+	return flowcontrol.NewTokenBucketRateLimiter(float32(limit)/60, limit)
+}
+
+func (d *Deduplicator) Post(workload string, namespace string, message string, fields []Field, severity string) error {
+	return d.PostTo(nil, workload, namespace, message, fields, severity)
+}
+
+// PostTo passes the notifier of the workload on with the notification and its summary
+func (d *Deduplicator) PostTo(notifier Interface, workload string, namespace string, message string, fields []Field, severity string) error {
+	key := fmt.Sprintf("%s\x00%s\x00%s", workload, namespace, message)
+
+	d.mu.Lock()
+	if e, ok := d.entries[key]; ok {
+		e.suppressed++
+		d.mu.Unlock()
+		return nil
+	}
+
+	allowed := d.allow(workload, namespace)
+	if d.window > 0 {
+		e := &dedupEntry{
+			notifier:  notifier,
+			workload:  workload,
+			namespace: namespace,
+			message:   message,
+			fields:    fields,
+			severity:  severity,
+		}
+		if !allowed {
+			e.suppressed = 1
+		}
+		d.entries[key] = e
+		time.AfterFunc(d.window, func() {
+			d.flush(key)
+		})
+	}
+	d.mu.Unlock()
+
+	if !allowed {
+		d.recorder.IncDroppedNotification(rateLimitPolicy)
+		if d.window == 0 && d.onError != nil {
+			d.onError(workload, namespace, ErrRateLimited)
+		}
+		return nil
+	}
+	return PostTo(d.notifier, notifier, workload, namespace, message, fields, severity)
+}
+
+// allow checks the per canary and the global rate limits, it must be called with the lock held
+func (d *Deduplicator) allow(workload, namespace string) bool {
+	if d.canaryLimit > 0 {
+		now := time.Now()
+		d.pruneLimiters(now)
+		canary := fmt.Sprintf("%s.%s", workload, namespace)
+		l, ok := d.limiters[canary]
+		if !ok {
+			l = &canaryLimiter{limiter: newPerMinuteLimiter(d.canaryLimit)}
+			d.limiters[canary] = l
+		}
+		l.lastUsed = now
+		if !l.limiter.TryAccept() {
+			return false
+		}
+	}
+	if d.global != nil && !d.global.TryAccept() {
+		return false
+	}
+	return true
+}
+
+// pruneLimiters evicts the limiters of the canaries that have been idle, the map
+// is scanned at most once per idle timeout, it must be called with the lock held
+func (d *Deduplicator) pruneLimiters(now time.Time) {
+	if now.Sub(d.lastPrune) < limiterIdleTimeout {
+		return
+	}
+	d.lastPrune = now
+	for canary, l := range d.limiters {
+		if now.Sub(l.lastUsed) >= limiterIdleTimeout {
+			delete(d.limiters, canary)
+		}
+	}
+}
+
+// flush closes the window of a message and sends the summary of the suppressed copies
+func (d *Deduplicator) flush(key string) {
+	d.mu.Lock()
+	e, ok := d.entries[key]
+	delete(d.entries, key)
+	d.mu.Unlock()
+
+	if !ok || e.suppressed == 0 {
+		return
+	}
+
+	summary := fmt.Sprintf("%d similar messages suppressed in the last %s: %s", e.suppressed, d.window, e.message)
+	if err := PostTo(d.notifier, e.notifier, e.workload, e.namespace, summary, e.fields, e.severity); err != nil && d.onError != nil {
+		d.onError(e.workload, e.namespace, err)
+	}
+}
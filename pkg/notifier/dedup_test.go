@@ -0,0 +1,155 @@
+package notifier
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/metrics"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeduplicator_SuppressesDuplicates(t *testing.T) {
+	n := &recordingNotifier{}
+	d := NewDeduplicator(n, 50*time.Millisecond, 0, 0, nil, metrics.NewRecorder("test", false))
+
+	for i := 0; i < 3; i++ {
+		if err := d.Post("podinfo", "test", "check failed", nil, SeverityWarn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Post("podinfo", "test", "rolled back", nil, SeverityError)
+	d.Post("other", "test", "check failed", nil, SeverityWarn)
+
+	posted := n.posted()
+	if len(posted) != 3 || posted[0] != "check failed" || posted[1] != "rolled back" || posted[2] != "check failed" {
+		t.Fatalf("expected a single copy per canary and message, got %v", posted)
+	}
+
+	// the suppressed copies are summarized when the window closes
+	time.Sleep(100 * time.Millisecond)
+	posted = n.posted()
+	if len(posted) != 4 || !strings.HasPrefix(posted[3], "2 similar messages suppressed") {
+		t.Fatalf("expected a summary of the suppressed copies, got %v", posted)
+	}
+
+	d.Post("podinfo", "test", "check failed", nil, SeverityWarn)
+	if posted := n.posted(); len(posted) != 5 {
+		t.Errorf("expected the message to be sent again after the window, got %v", posted)
+	}
+}
+
+func TestDeduplicator_NoWindow(t *testing.T) {
+	n := &recordingNotifier{}
+	d := NewDeduplicator(n, 0, 0, 0, nil, metrics.NewRecorder("test", false))
+
+	d.Post("podinfo", "test", "check failed", nil, SeverityWarn)
+	d.Post("podinfo", "test", "check failed", nil, SeverityWarn)
+	if posted := n.posted(); len(posted) != 2 {
+		t.Errorf("expected de-duplication to be disabled, got %v", posted)
+	}
+}
+
+func TestDeduplicator_CanaryLimit(t *testing.T) {
+	n := &recordingNotifier{}
+	d := NewDeduplicator(n, 0, 2, 0, nil, metrics.NewRecorder("test", false))
+
+	for _, msg := range []string{"a", "b", "c"} {
+		d.Post("podinfo", "test", msg, nil, SeverityInfo)
+	}
+	d.Post("other", "test", "a", nil, SeverityInfo)
+
+	posted := n.posted()
+	if len(posted) != 3 || posted[0] != "a" || posted[1] != "b" || posted[2] != "a" {
+		t.Errorf("expected the third message of podinfo to be limited, got %v", posted)
+	}
+}
+
+func TestDeduplicator_GlobalLimit(t *testing.T) {
+	n := &recordingNotifier{}
+	d := NewDeduplicator(n, 0, 0, 2, nil, metrics.NewRecorder("test", false))
+
+	d.Post("a", "test", "message", nil, SeverityInfo)
+	d.Post("b", "test", "message", nil, SeverityInfo)
+	d.Post("c", "test", "message", nil, SeverityInfo)
+	if posted := n.posted(); len(posted) != 2 {
+		t.Errorf("expected the third canary to be limited, got %v", posted)
+	}
+}
+
+func TestDeduplicator_LimitedMessagesAreSummarized(t *testing.T) {
+	n := &recordingNotifier{}
+	d := NewDeduplicator(n, 50*time.Millisecond, 1, 0, nil, metrics.NewRecorder("test", false))
+
+	d.Post("podinfo", "test", "a", nil, SeverityInfo)
+	d.Post("podinfo", "test", "b", nil, SeverityInfo)
+	time.Sleep(100 * time.Millisecond)
+
+	posted := n.posted()
+	if len(posted) != 2 || posted[0] != "a" || !strings.HasSuffix(posted[1], ": b") {
+		t.Errorf("expected the limited message in a summary, got %v", posted)
+	}
+}
+
+func TestDeduplicator_PrunesIdleLimiters(t *testing.T) {
+	d := NewDeduplicator(&recordingNotifier{}, 0, 10, 0, nil, metrics.NewRecorder("test", false))
+
+	d.Post("a", "test", "message", nil, SeverityInfo)
+	d.Post("b", "test", "message", nil, SeverityInfo)
+	if len(d.limiters) != 2 {
+		t.Fatalf("expected a limiter per canary, got %d", len(d.limiters))
+	}
+
+	// a is idle for longer than the timeout while b was just used
+	d.mu.Lock()
+	d.limiters["a.test"].lastUsed = time.Now().Add(-2 * limiterIdleTimeout)
+	d.lastPrune = time.Now().Add(-2 * limiterIdleTimeout)
+	d.mu.Unlock()
+
+	d.Post("c", "test", "message", nil, SeverityInfo)
+	if _, ok := d.limiters["a.test"]; ok || len(d.limiters) != 2 {
+		t.Errorf("expected the idle limiter to be evicted, got %v", d.limiters)
+	}
+}
+
+func TestDeduplicator_ReportsRateLimitedWithoutWindow(t *testing.T) {
+	n := &recordingNotifier{}
+	errs := &errorRecorder{}
+	d := NewDeduplicator(n, 0, 1, 0, errs.onError, metrics.NewRecorder("dedup", true))
+
+	d.Post("podinfo", "test", "a", nil, SeverityInfo)
+	d.Post("podinfo", "test", "b", nil, SeverityInfo)
+	d.Post("podinfo", "test", "c", nil, SeverityInfo)
+
+	// without a window no summary is sent, the dropped notifications are reported instead
+	if posted := n.posted(); len(posted) != 1 {
+		t.Errorf("expected the other messages to be limited, got %v", posted)
+	}
+	if reported := errs.list(); len(reported) != 2 || !errors.Is(reported[0], ErrRateLimited) {
+		t.Errorf("expected the limited messages to be reported, got %v", reported)
+	}
+	if dropped := droppedNotifications(t, "dedup", rateLimitPolicy); dropped != 2 {
+		t.Errorf("expected 2 rate limited notifications to be counted, got %v", dropped)
+	}
+}
+
+// droppedNotifications reads the dropped notifications counter of the policy from the default registry
+func droppedNotifications(t *testing.T, controller, policy string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != controller+"_notifications_dropped_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "policy" && label.GetValue() == policy {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
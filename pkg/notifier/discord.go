@@ -0,0 +1,77 @@
+package notifier
+
+import (
+	"fmt"
+	"net/url"
+)
+
+type Discord struct {
+	URL      string
+	Username string
+	client   *Client
+}
+
+type DiscordPayload struct {
+	Username string         `json:"username,omitempty"`
+	Embeds   []DiscordEmbed `json:"embeds"`
+}
+
+type DiscordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Color       int            `json:"color"`
+	Fields      []DiscordField `json:"fields,omitempty"`
+}
+
+type DiscordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+func NewDiscord(hookURL, username string, client *Client) (*Discord, error) {
+	_, err := url.ParseRequestURI(hookURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Discord hook URL")
+	}
+
+	return &Discord{
+		URL:      hookURL,
+		Username: username,
+		client:   clientOrDefault(client),
+	}, nil
+}
+
+func (d *Discord) Post(workload string, namespace string, message string, fields []Field, severity string) error {
+	// embed colours are decimal RGB values
+	color := 0x2EB886
+	switch severity {
+	case SeverityError:
+		color = 0xFF0000
+	case SeverityWarn:
+		color = 0xFFA500
+	}
+
+	dfields := make([]DiscordField, 0, len(fields))
+	for _, f := range fields {
+		dfields = append(dfields, DiscordField{f.Name, f.Value, false})
+	}
+
+	payload := DiscordPayload{
+		Username: d.Username,
+		Embeds: []DiscordEmbed{
+			{
+				Title:       fmt.Sprintf("%s.%s", workload, namespace),
+				Description: message,
+				Color:       color,
+				Fields:      dfields,
+			},
+		},
+	}
+
+	err := d.client.postMessage(d.URL, payload)
+	if err != nil {
+		return fmt.Errorf("postMessage failed: %w", err)
+	}
+	return nil
+}
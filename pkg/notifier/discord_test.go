@@ -0,0 +1,113 @@
+package notifier
+
+import (
+	"gopkg.in/h2non/gock.v1"
+	"testing"
+)
+
+func TestDiscord_Post(t *testing.T) {
+	client := withoutRetries(t)
+
+	tests := []struct {
+		severity string
+		color    int
+	}{
+		{SeverityInfo, 0x2EB886},
+		{SeverityWarn, 0xFFA500},
+		{SeverityError, 0xFF0000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.severity, func(t *testing.T) {
+			gock.New("https://discord.com").
+				Post("/api/webhooks/id/token").
+				MatchType("json").
+				JSON(map[string]interface{}{
+					"username": "example",
+					"embeds": []map[string]interface{}{
+						{
+							"title":       "podinfo.test",
+							"description": "Rollout of podinfo:3.1.0 started",
+							"color":       tt.color,
+							"fields": []map[string]interface{}{
+								{"name": "Image", "value": "podinfo:3.1.0", "inline": false},
+							},
+						},
+					},
+				}).
+				Reply(204)
+
+			discord, err := NewDiscord("https://discord.com/api/webhooks/id/token", "example", client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = discord.Post("podinfo", "test", "Rollout of podinfo:3.1.0 started",
+				[]Field{{Name: "Image", Value: "podinfo:3.1.0"}}, tt.severity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !gock.IsDone() {
+				t.Error("expected the embed to be posted")
+			}
+		})
+	}
+}
+
+func TestDiscord_PostWithoutFields(t *testing.T) {
+	client := withoutRetries(t)
+
+	// the fields are omitted instead of sent as an empty list
+	gock.New("https://discord.com").
+		Post("/api/webhooks/id/token").
+		MatchType("json").
+		JSON(map[string]interface{}{
+			"username": "example",
+			"embeds": []map[string]interface{}{
+				{"title": "podinfo.test", "description": "message", "color": 0x2EB886},
+			},
+		}).
+		Reply(204)
+
+	discord, err := NewDiscord("https://discord.com/api/webhooks/id/token", "example", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := discord.Post("podinfo", "test", "message", nil, SeverityInfo); err != nil {
+		t.Fatal(err)
+	}
+	if !gock.IsDone() {
+		t.Error("expected the embed to be posted")
+	}
+}
+
+func TestDiscord_PostWithoutUsername(t *testing.T) {
+	client := withoutRetries(t)
+
+	// the username is omitted so that Discord uses the name of the webhook
+	gock.New("https://discord.com").
+		Post("/api/webhooks/id/token").
+		MatchType("json").
+		JSON(map[string]interface{}{
+			"embeds": []map[string]interface{}{
+				{"title": "podinfo.test", "description": "message", "color": 0x2EB886},
+			},
+		}).
+		Reply(204)
+
+	discord, err := NewDiscord("https://discord.com/api/webhooks/id/token", "", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := discord.Post("podinfo", "test", "message", nil, SeverityInfo); err != nil {
+		t.Fatal(err)
+	}
+	if !gock.IsDone() {
+		t.Error("expected the embed to be posted")
+	}
+}
+
+func TestNewDiscord_Invalid(t *testing.T) {
+	if _, err := NewDiscord("not a url", "example", nil); err == nil {
+		t.Error("expected an error for an invalid hook URL")
+	}
+}
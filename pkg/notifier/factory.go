@@ -6,13 +6,18 @@ type Factory struct {
 	URL			string
 	Username	string
 	Channel		string
+	// Template is the body template of the generic webhook provider
+	Template	string
+	// Client posts the notifications of the providers, the default client is used when nil
+	Client		*Client
 }
 
-func NewFactory(url, username, channel string) *Factory {
+func NewFactory(url, username, channel string, client *Client) *Factory {
 	return &Factory{
 		URL:      url,
 		Username: username,
 		Channel:  channel,
+		Client:   client,
 	}
 }
 
@@ -24,9 +29,15 @@ func (f Factory) Notifier(provider string) (Interface, error){
 	var err error
 	switch provider {
 	case "slack" :
-		n, err = NewSlack(f.URL, f.Username, f.Channel)
+		n, err = NewSlack(f.URL, f.Username, f.Channel, f.Client)
 	case "rocket":
-		n, err = NewRocket(f.URL, f.Username, f.Channel)
+		n, err = NewRocket(f.URL, f.Username, f.Channel, f.Client)
+	case "msteams":
+		n, err = NewMSTeams(f.URL, f.Client)
+	case "discord":
+		n, err = NewDiscord(f.URL, f.Username, f.Client)
+	case "generic":
+		n, err = NewGeneric(f.URL, f.Template, f.Client)
 	default:
 		err = fmt.Errorf("provider %s not supported", provider)
 	}
@@ -0,0 +1,79 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"text/template"
+)
+
+// DefaultGenericTemplate is used when no body template is configured
+const DefaultGenericTemplate = `{"workload":{{ json .Workload }},"namespace":{{ json .Namespace }},"message":{{ json .Message }},"severity":{{ json .Severity }},"fields":{{ json .Fields }}}`
+
+// Generic posts a JSON body rendered from a user defined template
+type Generic struct {
+	URL      string
+	Template *template.Template
+	client   *Client
+}
+
+// GenericData is the data passed to the body template
+type GenericData struct {
+	Workload  string
+	Namespace string
+	Message   string
+	Fields    []Field
+	Severity  string
+}
+
+var genericFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func NewGeneric(hookURL, bodyTemplate string, client *Client) (*Generic, error) {
+	_, err := url.ParseRequestURI(hookURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid generic webhook URL")
+	}
+
+	if bodyTemplate == "" {
+		bodyTemplate = DefaultGenericTemplate
+	}
+	tmpl, err := template.New("generic").Funcs(genericFuncs).Parse(bodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid generic webhook template: %w", err)
+	}
+
+	return &Generic{
+		URL:      hookURL,
+		Template: tmpl,
+		client:   clientOrDefault(client),
+	}, nil
+}
+
+func (g *Generic) Post(workload string, namespace string, message string, fields []Field, severity string) error {
+	var body bytes.Buffer
+	err := g.Template.Execute(&body, GenericData{
+		Workload:  workload,
+		Namespace: namespace,
+		Message:   message,
+		Fields:    fields,
+		Severity:  severity,
+	})
+	if err != nil {
+		return fmt.Errorf("rendering generic webhook template failed: %w", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return fmt.Errorf("generic webhook template rendered invalid JSON: %s", body.String())
+	}
+
+	err = g.client.postData(g.URL, body.Bytes())
+	if err != nil {
+		return fmt.Errorf("postMessage failed: %w", err)
+	}
+	return nil
+}
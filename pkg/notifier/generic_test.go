@@ -0,0 +1,96 @@
+package notifier
+
+import (
+	"gopkg.in/h2non/gock.v1"
+	"strings"
+	"testing"
+)
+
+func TestGeneric_PostDefaultTemplate(t *testing.T) {
+	client := withoutRetries(t)
+
+	gock.New("https://hooks.example.com").
+		Post("/notify").
+		MatchType("json").
+		JSON(map[string]interface{}{
+			"workload":  "podinfo",
+			"namespace": "test",
+			"message":   `Rollout of "podinfo:3.1.0" failed`,
+			"severity":  "error",
+			"fields": []map[string]string{
+				{"name": "Image", "value": "podinfo:3.1.0"},
+			},
+		}).
+		Reply(200)
+
+	generic, err := NewGeneric("https://hooks.example.com/notify", "", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = generic.Post("podinfo", "test", `Rollout of "podinfo:3.1.0" failed`,
+		[]Field{{Name: "Image", Value: "podinfo:3.1.0"}}, SeverityError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gock.IsDone() {
+		t.Error("expected the body to be posted")
+	}
+}
+
+func TestGeneric_PostCustomTemplate(t *testing.T) {
+	client := withoutRetries(t)
+
+	gock.New("https://hooks.example.com").
+		Post("/notify").
+		MatchType("json").
+		JSON(map[string]interface{}{
+			"text":  "podinfo.test: deployed",
+			"level": "info",
+			"image": "podinfo:3.1.0",
+		}).
+		Reply(200)
+
+	tmpl := `{"text":{{ json (printf "%s.%s: %s" .Workload .Namespace .Message) }},"level":{{ json .Severity }}` +
+		`{{ range .Fields }}{{ if eq .Name "Image" }},"image":{{ json .Value }}{{ end }}{{ end }}}`
+	generic, err := NewGeneric("https://hooks.example.com/notify", tmpl, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = generic.Post("podinfo", "test", "deployed", []Field{{Name: "Image", Value: "podinfo:3.1.0"}}, SeverityInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gock.IsDone() {
+		t.Error("expected the body to be posted")
+	}
+}
+
+func TestGeneric_PostInvalidJSON(t *testing.T) {
+	client := withoutRetries(t)
+
+	gock.New("https://hooks.example.com").
+		Post("/notify").
+		Reply(200)
+
+	// the message is not escaped so the quotes break the body
+	generic, err := NewGeneric("https://hooks.example.com/notify", `{"text":"{{ .Message }}"}`, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = generic.Post("podinfo", "test", `say "hi"`, nil, SeverityInfo)
+	if err == nil || !strings.Contains(err.Error(), "invalid JSON") {
+		t.Fatalf("expected an invalid JSON error, got %v", err)
+	}
+	if gock.IsDone() {
+		t.Error("expected no request for an invalid body")
+	}
+}
+
+func TestNewGeneric_Invalid(t *testing.T) {
+	if _, err := NewGeneric("not a url", "", nil); err == nil {
+		t.Error("expected an error for an invalid hook URL")
+	}
+	if _, err := NewGeneric("https://hooks.example.com/notify", `{{ .Message `, nil); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
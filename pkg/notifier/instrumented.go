@@ -0,0 +1,24 @@
+package notifier
+
+import "github.com/zhouzhihu/k8s-example-crd/pkg/metrics"
+
+// Instrumented counts the notifications sent and failed through a provider
+type Instrumented struct {
+	Provider string
+	Notifier Interface
+	Recorder metrics.Recorder
+}
+
+func NewInstrumented(provider string, notifier Interface, recorder metrics.Recorder) *Instrumented {
+	return &Instrumented{
+		Provider: provider,
+		Notifier: notifier,
+		Recorder: recorder,
+	}
+}
+
+func (i *Instrumented) Post(workload string, namespace string, message string, fields []Field, severity string) error {
+	err := i.Notifier.Post(workload, namespace, message, fields, severity)
+	i.Recorder.IncNotification(i.Provider, err)
+	return err
+}
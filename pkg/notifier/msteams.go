@@ -0,0 +1,77 @@
+package notifier
+
+import (
+	"fmt"
+	"net/url"
+)
+
+type MSTeams struct {
+	URL    string
+	client *Client
+}
+
+// MSTeamsPayload is a legacy actionable message card
+type MSTeamsPayload struct {
+	Type       string           `json:"@type"`
+	Context    string           `json:"@context"`
+	ThemeColor string           `json:"themeColor"`
+	Summary    string           `json:"summary"`
+	Sections   []MSTeamsSection `json:"sections"`
+}
+
+type MSTeamsSection struct {
+	ActivityTitle    string         `json:"activityTitle"`
+	ActivitySubtitle string         `json:"activitySubtitle"`
+	Facts            []MSTeamsField `json:"facts"`
+}
+
+type MSTeamsField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func NewMSTeams(hookURL string, client *Client) (*MSTeams, error) {
+	_, err := url.ParseRequestURI(hookURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MS Teams hook URL")
+	}
+
+	return &MSTeams{
+		URL:    hookURL,
+		client: clientOrDefault(client),
+	}, nil
+}
+
+func (s *MSTeams) Post(workload string, namespace string, message string, fields []Field, severity string) error {
+	facts := make([]MSTeamsField, 0, len(fields))
+	for _, f := range fields {
+		facts = append(facts, MSTeamsField{f.Name, f.Value})
+	}
+
+	payload := MSTeamsPayload{
+		Type:       "MessageCard",
+		Context:    "http://schema.org/extensions",
+		ThemeColor: "0076D7",
+		Summary:    fmt.Sprintf("%s.%s", workload, namespace),
+		Sections: []MSTeamsSection{
+			{
+				ActivityTitle:    message,
+				ActivitySubtitle: fmt.Sprintf("%s.%s", workload, namespace),
+				Facts:            facts,
+			},
+		},
+	}
+
+	switch severity {
+	case SeverityError:
+		payload.ThemeColor = "FF0000"
+	case SeverityWarn:
+		payload.ThemeColor = "FFA500"
+	}
+
+	err := s.client.postMessage(s.URL, payload)
+	if err != nil {
+		return fmt.Errorf("postMessage failed: %w", err)
+	}
+	return nil
+}
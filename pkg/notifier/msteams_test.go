@@ -0,0 +1,88 @@
+package notifier
+
+import (
+	"context"
+	"gopkg.in/h2non/gock.v1"
+	"testing"
+	"time"
+)
+
+// withoutRetries returns a client making a single attempt per notification
+func withoutRetries(t *testing.T) *Client {
+	t.Cleanup(gock.Off)
+	return NewClient(context.Background(),
+		RetryPolicy{Retries: 0, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Timeout: time.Second}, nil)
+}
+
+func TestMSTeams_Post(t *testing.T) {
+	client := withoutRetries(t)
+
+	tests := []struct {
+		severity string
+		color    string
+	}{
+		{SeverityInfo, "0076D7"},
+		{SeverityWarn, "FFA500"},
+		{SeverityError, "FF0000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.severity, func(t *testing.T) {
+			gock.New("https://outlook.office.com").
+				Post("/webhook/token").
+				MatchType("json").
+				JSON(map[string]interface{}{
+					"@type":      "MessageCard",
+					"@context":   "http://schema.org/extensions",
+					"themeColor": tt.color,
+					"summary":    "podinfo.test",
+					"sections": []map[string]interface{}{
+						{
+							"activityTitle":    "Rollout of podinfo:3.1.0 started",
+							"activitySubtitle": "podinfo.test",
+							"facts": []map[string]string{
+								{"name": "Image", "value": "podinfo:3.1.0"},
+							},
+						},
+					},
+				}).
+				Reply(200)
+
+			teams, err := NewMSTeams("https://outlook.office.com/webhook/token", client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = teams.Post("podinfo", "test", "Rollout of podinfo:3.1.0 started",
+				[]Field{{Name: "Image", Value: "podinfo:3.1.0"}}, tt.severity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !gock.IsDone() {
+				t.Error("expected the card to be posted")
+			}
+		})
+	}
+}
+
+func TestMSTeams_PostError(t *testing.T) {
+	client := withoutRetries(t)
+
+	gock.New("https://outlook.office.com").
+		Post("/webhook/token").
+		Reply(400).
+		BodyString("bad card")
+
+	teams, err := NewMSTeams("https://outlook.office.com/webhook/token", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := teams.Post("podinfo", "test", "message", nil, SeverityInfo); err == nil {
+		t.Error("expected an error for status 400")
+	}
+}
+
+func TestNewMSTeams_InvalidURL(t *testing.T) {
+	if _, err := NewMSTeams("not a url", nil); err == nil {
+		t.Error("expected an error for an invalid hook URL")
+	}
+}
@@ -1,10 +1,17 @@
 package notifier
 
+// Severity levels passed to Interface.Post
+const (
+	SeverityInfo  = "info"
+	SeverityWarn  = "warn"
+	SeverityError = "error"
+)
+
 type Interface interface {
 	Post(workload string, namespace string, message string, fields []Field, severity string) error
 }
 
 type Field struct {
-	Name 	string
-	Value 	string
+	Name 	string	`json:"name"`
+	Value 	string	`json:"value"`
 }
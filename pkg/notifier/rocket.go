@@ -10,12 +10,13 @@ type Rocket struct {
 	URL			string
 	Username	string
 	Channel		string
+	client		*Client
 }
 
-func NewRocket(hookURL, username, channel string) (*Rocket, error){
+func NewRocket(hookURL, username, channel string, client *Client) (*Rocket, error){
 	_, err := url.ParseRequestURI(hookURL)
 	if err != nil {
-		return nil, fmt.Errorf("invalid Rocket hook URL：%s", hookURL)
+		return nil, fmt.Errorf("invalid Rocket hook URL")
 	}
 
 	if username == "" {
@@ -30,6 +31,7 @@ func NewRocket(hookURL, username, channel string) (*Rocket, error){
 		URL:      hookURL,
 		Username: username,
 		Channel:  channel,
+		client:   clientOrDefault(client),
 	}, nil
 }
 
@@ -41,7 +43,7 @@ func (r *Rocket) Post(workload string, namespace string, message string, fields
 	}
 
 	color := "#0076D7"
-	if severity == "error" {
+	if severity == SeverityError {
 		color = "#FF0000"
 	}
 
@@ -60,7 +62,7 @@ func (r *Rocket) Post(workload string, namespace string, message string, fields
 
 	payload.Attachments = []SlackAttachment{a}
 
-	err := postMessage(r.URL, payload)
+	err := r.client.postMessage(r.URL, payload)
 	if err != nil {
 		return fmt.Errorf("postMessage failed: %w", err)
 	}
@@ -0,0 +1,57 @@
+package notifier
+
+import "sync"
+
+// Routed is implemented by the notifiers that carry the notifier of a workload
+// along with its notifications, a nil notifier stands for the default one
+type Routed interface {
+	PostTo(notifier Interface, workload string, namespace string, message string, fields []Field, severity string) error
+}
+
+// PostTo sends a notification through next to the notifier of a workload,
+// the notifier is posted to directly when next does not carry it
+func PostTo(next Interface, notifier Interface, workload string, namespace string, message string, fields []Field, severity string) error {
+	if routed, ok := next.(Routed); ok {
+		return routed.PostTo(notifier, workload, namespace, message, fields, severity)
+	}
+	if notifier != nil {
+		return notifier.Post(workload, namespace, message, fields, severity)
+	}
+	return next.Post(workload, namespace, message, fields, severity)
+}
+
+// Router sends the notifications of a workload to its own notifier and
+// falls back to the default notifier
+type Router struct {
+	mu       sync.RWMutex
+	notifier Interface
+}
+
+func NewRouter(notifier Interface) *Router {
+	return &Router{
+		notifier: notifier,
+	}
+}
+
+// SetNotifier replaces the default notifier, it can be called while notifications are posted
+func (r *Router) SetNotifier(notifier Interface) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifier = notifier
+}
+
+func (r *Router) Post(workload string, namespace string, message string, fields []Field, severity string) error {
+	return r.PostTo(nil, workload, namespace, message, fields, severity)
+}
+
+// PostTo sends the notification to the notifier of the workload when it has one
+func (r *Router) PostTo(notifier Interface, workload string, namespace string, message string, fields []Field, severity string) error {
+	if notifier != nil {
+		return notifier.Post(workload, namespace, message, fields, severity)
+	}
+
+	r.mu.RLock()
+	defaultNotifier := r.notifier
+	r.mu.RUnlock()
+	return defaultNotifier.Post(workload, namespace, message, fields, severity)
+}
@@ -0,0 +1,43 @@
+package notifier
+
+import (
+	"github.com/zhouzhihu/k8s-example-crd/pkg/metrics"
+	"testing"
+	"time"
+)
+
+func TestRouter_PostsToTheNotifierOfTheWorkload(t *testing.T) {
+	global := &recordingNotifier{}
+	team := &recordingNotifier{}
+	router := NewRouter(global)
+	a := newTestAsync(t, router, 10, OverflowDropNewest, nil)
+	d := NewDeduplicator(a, time.Hour, 0, 0, nil, metrics.NewRecorder("test", false))
+
+	if err := PostTo(d, team, "podinfo", "test", "deleted", nil, SeverityInfo); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Post("podinfo", "test", "started", nil, SeverityInfo); err != nil {
+		t.Fatal(err)
+	}
+	drain(a)
+
+	// the notifier travels with the message through the deduplicator and the queue
+	if posted := team.posted(); len(posted) != 1 || posted[0] != "deleted" {
+		t.Errorf("expected the workload notifier to receive its message, got %v", posted)
+	}
+	if posted := global.posted(); len(posted) != 1 || posted[0] != "started" {
+		t.Errorf("expected the default notifier to receive the other message, got %v", posted)
+	}
+}
+
+func TestPostTo_WithoutRouting(t *testing.T) {
+	global := &recordingNotifier{}
+	team := &recordingNotifier{}
+
+	if err := PostTo(global, team, "podinfo", "test", "message", nil, SeverityInfo); err != nil {
+		t.Fatal(err)
+	}
+	if len(team.posted()) != 1 || len(global.posted()) != 0 {
+		t.Errorf("expected the workload notifier to be posted to directly, got %v and %v", team.posted(), global.posted())
+	}
+}
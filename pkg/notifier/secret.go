@@ -0,0 +1,106 @@
+package notifier
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"strings"
+	"sync"
+)
+
+// SecretReference locates the hook URL of a provider in a Secret
+type SecretReference struct {
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// ParseSecretReference parses a namespace/name/key reference
+func ParseSecretReference(ref string) (SecretReference, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return SecretReference{}, fmt.Errorf("invalid secret reference %s, expected namespace/name/key", ref)
+	}
+	return SecretReference{
+		Namespace: parts[0],
+		Name:      parts[1],
+		Key:       parts[2],
+	}, nil
+}
+
+func (r SecretReference) String() string {
+	return fmt.Sprintf("%s/%s/%s", r.Namespace, r.Name, r.Key)
+}
+
+// SecretGetter returns a Secret from a cache or from the API server
+type SecretGetter func(namespace, name string) (*corev1.Secret, error)
+
+// ListerSecretGetter reads the Secrets from an informer cache
+func ListerSecretGetter(lister corelisters.SecretLister) SecretGetter {
+	return func(namespace, name string) (*corev1.Secret, error) {
+		return lister.Secrets(namespace).Get(name)
+	}
+}
+
+// SecretNotifier reads the hook URL from a Secret on every notification,
+// the provider is rebuilt when the Secret changes so that rotated URLs are
+// used without a restart
+type SecretNotifier struct {
+	provider  string
+	factory   Factory
+	secret    SecretReference
+	getSecret SecretGetter
+
+	mu              sync.Mutex
+	resourceVersion string
+	notifier        Interface
+}
+
+// NewSecretNotifier creates a notifier of the provider, the URL of the factory is
+// replaced by the value of the Secret
+func NewSecretNotifier(provider string, factory Factory, secret SecretReference, getSecret SecretGetter) *SecretNotifier {
+	return &SecretNotifier{
+		provider:  provider,
+		factory:   factory,
+		secret:    secret,
+		getSecret: getSecret,
+	}
+}
+
+func (s *SecretNotifier) Post(workload string, namespace string, message string, fields []Field, severity string) error {
+	n, err := s.current()
+	if err != nil {
+		return err
+	}
+	return n.Post(workload, namespace, message, fields, severity)
+}
+
+// current returns the provider built from the latest version of the Secret
+func (s *SecretNotifier) current() (Interface, error) {
+	secret, err := s.getSecret(s.secret.Namespace, s.secret.Name)
+	if err != nil {
+		return nil, fmt.Errorf("reading secret %s/%s failed: %w", s.secret.Namespace, s.secret.Name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.notifier != nil && s.resourceVersion == secret.ResourceVersion {
+		return s.notifier, nil
+	}
+
+	address := strings.TrimSpace(string(secret.Data[s.secret.Key]))
+	if address == "" {
+		return nil, fmt.Errorf("secret %s has no hook URL", s.secret)
+	}
+	factory := s.factory
+	factory.URL = address
+	n, err := factory.Notifier(s.provider)
+	if err != nil {
+		// the provider errors never hold the URL
+		return nil, fmt.Errorf("creating %s notifier from secret %s failed: %w", s.provider, s.secret, err)
+	}
+
+	s.notifier = n
+	s.resourceVersion = secret.ResourceVersion
+	return n, nil
+}
@@ -0,0 +1,113 @@
+package notifier
+
+import (
+	"errors"
+	"gopkg.in/h2non/gock.v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+)
+
+// secretStore stands for the Secret cache, the Secret is replaced to rotate the hook URL
+type secretStore struct {
+	secret *corev1.Secret
+}
+
+func (s *secretStore) get(namespace, name string) (*corev1.Secret, error) {
+	if s.secret == nil || s.secret.Namespace != namespace || s.secret.Name != name {
+		return nil, errors.New(`secrets "` + name + `" not found`)
+	}
+	return s.secret, nil
+}
+
+func (s *secretStore) set(resourceVersion string, data map[string]string) {
+	s.secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "hooks", Namespace: "test", ResourceVersion: resourceVersion},
+		Data:       map[string][]byte{},
+	}
+	for key, value := range data {
+		s.secret.Data[key] = []byte(value)
+	}
+}
+
+func newTestSecretNotifier(t *testing.T, store *secretStore) *SecretNotifier {
+	factory := NewFactory("", "", "", withoutRetries(t))
+	return NewSecretNotifier("generic", *factory,
+		SecretReference{Namespace: "test", Name: "hooks", Key: "address"}, store.get)
+}
+
+func TestSecretNotifier_Rotation(t *testing.T) {
+	store := &secretStore{}
+	store.set("1", map[string]string{"address": "https://hooks.example.com/first"})
+	n := newTestSecretNotifier(t, store)
+
+	gock.New("https://hooks.example.com").Post("/first").Times(2).Reply(200)
+	gock.New("https://hooks.example.com").Post("/second").Reply(200)
+
+	for i := 0; i < 2; i++ {
+		if err := n.Post("podinfo", "test", "started", nil, SeverityInfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := n.notifier
+
+	// the updated Secret rebuilds the provider for the next notification
+	store.set("2", map[string]string{"address": "https://hooks.example.com/second"})
+	if err := n.Post("podinfo", "test", "succeeded", nil, SeverityInfo); err != nil {
+		t.Fatal(err)
+	}
+	if !gock.IsDone() {
+		t.Error("expected the notifications to follow the rotated hook URL")
+	}
+	if n.notifier == first {
+		t.Error("expected the provider to be rebuilt")
+	}
+}
+
+func TestSecretNotifier_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret map[string]string
+		err    string
+	}{
+		{"missing secret", nil, "reading secret test/hooks failed"},
+		{"missing key", map[string]string{"token": "https://hooks.example.com/secret-token"}, "secret test/hooks/address has no hook URL"},
+		{"empty key", map[string]string{"address": " "}, "secret test/hooks/address has no hook URL"},
+		{"invalid URL", map[string]string{"address": "hooks.example.com/secret-token"}, "creating generic notifier from secret test/hooks/address failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &secretStore{}
+			if tt.secret != nil {
+				store.set("1", tt.secret)
+			}
+			n := newTestSecretNotifier(t, store)
+
+			err := n.Post("podinfo", "test", "started", nil, SeverityInfo)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+			if err != nil && strings.Contains(err.Error(), "secret-token") {
+				t.Errorf("expected the error to hide the hook URL, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSecretNotifier_PostErrorHidesHookURL(t *testing.T) {
+	store := &secretStore{}
+	store.set("1", map[string]string{"address": "https://hooks.example.com/secret-token"})
+	n := newTestSecretNotifier(t, store)
+
+	gock.New("https://hooks.example.com").Post("/secret-token").ReplyError(errors.New("connection refused"))
+
+	err := n.Post("podinfo", "test", "started", nil, SeverityInfo)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("expected the error to hide the hook URL, got %v", err)
+	}
+}
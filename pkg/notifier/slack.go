@@ -10,6 +10,7 @@ type Slack struct {
 	URL			string
 	Username	string
 	Channel 	string
+	client		*Client
 }
 
 type SlackPayload struct {
@@ -35,10 +36,10 @@ type SlackField struct {
 	Short		bool			`json:"short"`
 }
 
-func NewSlack(hookURL, username, channel string) (*Slack, error) {
+func NewSlack(hookURL, username, channel string, client *Client) (*Slack, error) {
 	_, err := url.ParseRequestURI(hookURL)
 	if err != nil {
-		return nil, fmt.Errorf("invalid Slack hook URL %s", hookURL)
+		return nil, fmt.Errorf("invalid Slack hook URL")
 	}
 	if username == "" {
 		return nil, errors.New("empty Slack username")
@@ -52,6 +53,7 @@ func NewSlack(hookURL, username, channel string) (*Slack, error) {
 		URL:      hookURL,
 		Username: username,
 		Channel:  channel,
+		client:   clientOrDefault(client),
 	}, nil
 }
 
@@ -63,7 +65,7 @@ func (s *Slack) Post(workload string, namespace string, message string, field []
 	}
 
 	color := "good"
-	if severity == "error" {
+	if severity == SeverityError {
 		color = "danger"
 	}
 
@@ -84,9 +86,9 @@ func (s *Slack) Post(workload string, namespace string, message string, field []
 	}
 
 	payload.Attachments = []SlackAttachment{a}
-	err := postMessage(s.URL, payload)
+	err := s.client.postMessage(s.URL, payload)
 	if err != nil {
-		fmt.Errorf("postMessage failed： %w", err)
+		return fmt.Errorf("postMessage failed： %w", err)
 	}
 	return nil
 }
\ No newline at end of file
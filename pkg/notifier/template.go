@@ -0,0 +1,167 @@
+package notifier
+
+import (
+	"bytes"
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Event types used to select a message template
+const (
+	EventStarted   = "started"
+	EventSucceeded = "succeeded"
+	EventFailed    = "failed"
+	EventDeleted   = "deleted"
+	// EventDefault is used for the events without a template of their own
+	EventDefault = "default"
+)
+
+var templateEvents = []string{EventStarted, EventSucceeded, EventFailed, EventDeleted, EventDefault}
+
+// TemplateData is the data passed to the message templates
+type TemplateData struct {
+	Canary   *examplev1beta1.Canary
+	Event    string
+	Message  string
+	Fields   []Field
+	Severity string
+}
+
+// Templates renders the notification messages per event type
+type Templates struct {
+	templates map[string]*template.Template
+}
+
+// LoadTemplates reads the templates from a directory holding one <event>.tmpl
+// file per event, like a mounted ConfigMap, or from a YAML file mapping the
+// event types to templates, every template is validated against a sample canary
+func LoadTemplates(path string) (*Templates, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading templates failed: %w", err)
+	}
+
+	sources := map[string]string{}
+	if info.IsDir() {
+		for _, event := range templateEvents {
+			data, err := ioutil.ReadFile(filepath.Join(path, event+".tmpl"))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading template %s failed: %w", event, err)
+			}
+			sources[event] = string(data)
+		}
+	} else {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading templates failed: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &sources); err != nil {
+			return nil, fmt.Errorf("decoding templates failed: %w", err)
+		}
+	}
+
+	return NewTemplates(sources)
+}
+
+// NewTemplates parses and validates the templates keyed by event type
+func NewTemplates(sources map[string]string) (*Templates, error) {
+	t := &Templates{
+		templates: map[string]*template.Template{},
+	}
+	for event, source := range sources {
+		if !isTemplateEvent(event) {
+			return nil, fmt.Errorf("unknown template event %s, expected one of %s", event, strings.Join(templateEvents, ", "))
+		}
+		// the labels and annotations of a canary are optional, a missing key renders empty
+		tmpl, err := template.New(event).Option("missingkey=zero").Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("parsing template %s failed: %w", event, err)
+		}
+		t.templates[event] = tmpl
+	}
+
+	// execute every template once so that references to unknown fields fail fast
+	for event := range t.templates {
+		if _, err := t.Render(sampleTemplateData(event)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// sampleTemplateData returns the data of a canary in the middle of a rollout, the
+// optional fields are set so that only the references to unknown fields fail
+func sampleTemplateData(event string) TemplateData {
+	now := metav1.Now()
+	return TemplateData{
+		Canary: &examplev1beta1.Canary{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "podinfo",
+				Namespace:   "test",
+				Labels:      map[string]string{"app": "podinfo"},
+				Annotations: map[string]string{"example.app/owner": "team"},
+			},
+			Spec: examplev1beta1.CanarySpec{
+				Image:     "podinfo:2.0",
+				Replicas:  4,
+				TargetRef: &examplev1beta1.CanaryTargetReference{Name: "podinfo"},
+				Steps:     []int32{25, 50},
+				Interval:  &metav1.Duration{Duration: time.Minute},
+				Analysis:  &examplev1beta1.CanaryAnalysis{Interval: &metav1.Duration{Duration: time.Minute}, Threshold: 2},
+			},
+			Status: examplev1beta1.CanaryStatus{
+				Phase:              examplev1beta1.CanaryPhaseProgressing,
+				LastTransitionTime: now,
+				StableImage:        "podinfo:1.0",
+				CanaryImage:        "podinfo:2.0",
+				CurrentStep:        1,
+				CurrentWeight:      50,
+				StepStartTime:      &now,
+			},
+		},
+		Event:    event,
+		Message:  "validation",
+		Fields:   []Field{{Name: "name", Value: "value"}},
+		Severity: SeverityInfo,
+	}
+}
+
+// Render returns the message of the event, the default message is kept
+// when no template is configured for the event
+func (t *Templates) Render(data TemplateData) (string, error) {
+	if t == nil {
+		return data.Message, nil
+	}
+	tmpl, ok := t.templates[data.Event]
+	if !ok {
+		if tmpl, ok = t.templates[EventDefault]; !ok {
+			return data.Message, nil
+		}
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("rendering template %s failed: %w", tmpl.Name(), err)
+	}
+	return out.String(), nil
+}
+
+func isTemplateEvent(event string) bool {
+	for _, e := range templateEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package notifier
+
+import (
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"io/ioutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTemplates writes the files to a new directory and returns its path
+func writeTemplates(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func renderEvent(t *testing.T, templates *Templates, event string) string {
+	t.Helper()
+	message, err := templates.Render(TemplateData{
+		Canary: &examplev1beta1.Canary{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "test"},
+			Spec:       examplev1beta1.CanarySpec{Image: "podinfo:2.0"},
+		},
+		Event:    event,
+		Message:  "built-in " + event,
+		Severity: SeverityInfo,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"failed.tmpl":  "{{ .Canary.Name }} failed with {{ .Canary.Spec.Image }}",
+		"default.tmpl": "{{ .Canary.Name }}.{{ .Canary.Namespace }}: {{ .Message }}",
+		// the other files of a mounted ConfigMap are ignored
+		"README": "{{ .Unknown }}",
+	})
+	file := filepath.Join(writeTemplates(t, map[string]string{
+		"templates.yaml": "failed: '{{ .Canary.Name }} failed with {{ .Canary.Spec.Image }}'\n" +
+			"default: '{{ .Canary.Name }}.{{ .Canary.Namespace }}: {{ .Message }}'\n",
+	}), "templates.yaml")
+
+	for name, path := range map[string]string{"directory": dir, "YAML file": file} {
+		t.Run(name, func(t *testing.T) {
+			templates, err := LoadTemplates(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the template of the event overrides the default template
+			if got := renderEvent(t, templates, EventFailed); got != "podinfo failed with podinfo:2.0" {
+				t.Errorf("unexpected failed message %q", got)
+			}
+			if got := renderEvent(t, templates, EventStarted); got != "podinfo.test: built-in started" {
+				t.Errorf("expected the default template for the started event, got %q", got)
+			}
+		})
+	}
+}
+
+func TestLoadTemplates_WithoutDefault(t *testing.T) {
+	templates, err := LoadTemplates(writeTemplates(t, map[string]string{
+		"deleted.tmpl": "{{ .Canary.Name }} is gone",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the built-in message is kept for the events without a template
+	if got := renderEvent(t, templates, EventDeleted); got != "podinfo is gone" {
+		t.Errorf("unexpected deleted message %q", got)
+	}
+	if got := renderEvent(t, templates, EventSucceeded); got != "built-in succeeded" {
+		t.Errorf("expected the built-in message, got %q", got)
+	}
+}
+
+func TestLoadTemplates_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		path  string
+		err   string
+	}{
+		{"missing path", nil, "missing", "reading templates failed"},
+		{"unknown event", map[string]string{"templates.yaml": "rollback: 'rolled back'"}, "templates.yaml", "unknown template event rollback"},
+		{"invalid YAML", map[string]string{"templates.yaml": "failed: ["}, "templates.yaml", "decoding templates failed"},
+		{"syntax error", map[string]string{"failed.tmpl": "{{ .Canary.Name "}, "", "parsing template failed failed"},
+		{"unknown field", map[string]string{"failed.tmpl": "{{ .Canary.Spec.Owner }}"}, "", "rendering template failed failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadTemplates(filepath.Join(writeTemplates(t, tt.files), tt.path))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestNewTemplates_OptionalFields(t *testing.T) {
+	// the optional fields of a canary are set in the validation sample
+	templates, err := NewTemplates(map[string]string{
+		EventStarted: "{{ .Canary.Spec.TargetRef.Name }} {{ .Canary.Status.StepStartTime.Time }} {{ index .Canary.Labels \"team\" }}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a missing label renders empty
+	canary := &examplev1beta1.Canary{Spec: examplev1beta1.CanarySpec{TargetRef: &examplev1beta1.CanaryTargetReference{Name: "podinfo"}}}
+	now := metav1.Now()
+	canary.Status.StepStartTime = &now
+	message, err := templates.Render(TemplateData{Canary: canary, Event: EventStarted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(message, "podinfo ") || !strings.HasSuffix(message, " ") {
+		t.Errorf("unexpected message %q", message)
+	}
+}
@@ -0,0 +1,133 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoValuesFound is returned when the query matched no series
+var ErrNoValuesFound = errors.New("no values found")
+
+// Client runs instant PromQL queries against the Prometheus HTTP API
+type Client struct {
+	address *url.URL
+	timeout time.Duration
+	client  *http.Client
+}
+
+type queryResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+type vectorSample struct {
+	Value []interface{} `json:"value"`
+}
+
+// NewClient returns a client of the Prometheus server at the address,
+// the timeout bounds each query
+func NewClient(address string, timeout time.Duration) (*Client, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Prometheus address: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Prometheus address %s, an http or https URL is required", address)
+	}
+
+	return &Client{
+		address: u,
+		timeout: timeout,
+		client:  &http.Client{},
+	}, nil
+}
+
+// Query returns the value of an instant query, the result must be a scalar
+// or a vector holding a single sample
+func (c *Client) Query(query string) (float64, error) {
+	u := *c.address
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v1/query"
+	u.RawQuery = url.Values{"query": {query}}.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("creating Prometheus request failed: %w", err)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("querying Prometheus failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, fmt.Errorf("reading Prometheus response failed: %w", err)
+	}
+
+	var qr queryResponse
+	if err := json.Unmarshal(body, &qr); err != nil {
+		return 0, fmt.Errorf("decoding Prometheus response failed, status %d: %w", res.StatusCode, err)
+	}
+	if qr.Status != "success" {
+		return 0, fmt.Errorf("query failed with %s: %s", qr.ErrorType, qr.Error)
+	}
+
+	var value []interface{}
+	switch qr.Data.ResultType {
+	case "scalar":
+		if err := json.Unmarshal(qr.Data.Result, &value); err != nil {
+			return 0, fmt.Errorf("decoding Prometheus scalar failed: %w", err)
+		}
+	case "vector":
+		var samples []vectorSample
+		if err := json.Unmarshal(qr.Data.Result, &samples); err != nil {
+			return 0, fmt.Errorf("decoding Prometheus vector failed: %w", err)
+		}
+		if len(samples) == 0 {
+			return 0, ErrNoValuesFound
+		}
+		if len(samples) > 1 {
+			return 0, fmt.Errorf("query returned %d series, a single one is required", len(samples))
+		}
+		value = samples[0].Value
+	default:
+		return 0, fmt.Errorf("unsupported result type %s, a scalar or a vector is required", qr.Data.ResultType)
+	}
+
+	return parseSample(value)
+}
+
+// parseSample reads the value of a [timestamp, "value"] pair
+func parseSample(sample []interface{}) (float64, error) {
+	if len(sample) != 2 {
+		return 0, fmt.Errorf("invalid Prometheus sample %v", sample)
+	}
+	s, ok := sample[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid Prometheus sample value %v", sample[1])
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Prometheus sample value %s: %w", s, err)
+	}
+	if math.IsNaN(v) {
+		return 0, ErrNoValuesFound
+	}
+	return v, nil
+}
@@ -0,0 +1,173 @@
+package prometheus
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer answers the instant queries with the body and records the last query
+func newTestServer(t *testing.T, status int, body string, query *string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			t.Errorf("expected path /api/v1/query, got %s", r.URL.Path)
+		}
+		if query != nil {
+			*query = r.URL.Query().Get("query")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestClient_Query(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		value  float64
+		err    string
+	}{
+		{
+			name:   "scalar",
+			status: http.StatusOK,
+			body:   `{"status":"success","data":{"resultType":"scalar","result":[1614556800,"0.25"]}}`,
+			value:  0.25,
+		},
+		{
+			name:   "vector",
+			status: http.StatusOK,
+			body:   `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"pod":"podinfo"},"value":[1614556800,"99.5"]}]}}`,
+			value:  99.5,
+		},
+		{
+			name:   "empty vector",
+			status: http.StatusOK,
+			body:   `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			err:    ErrNoValuesFound.Error(),
+		},
+		{
+			name:   "multiple series",
+			status: http.StatusOK,
+			body: `{"status":"success","data":{"resultType":"vector","result":[` +
+				`{"metric":{"pod":"a"},"value":[1614556800,"1"]},{"metric":{"pod":"b"},"value":[1614556800,"2"]}]}}`,
+			err: "2 series",
+		},
+		{
+			name:   "NaN",
+			status: http.StatusOK,
+			body:   `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1614556800,"NaN"]}]}}`,
+			err:    ErrNoValuesFound.Error(),
+		},
+		{
+			name:   "matrix",
+			status: http.StatusOK,
+			body:   `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+			err:    "unsupported result type matrix",
+		},
+		{
+			name:   "error status",
+			status: http.StatusBadRequest,
+			body:   `{"status":"error","errorType":"bad_data","error":"parse error at char 5"}`,
+			err:    "query failed with bad_data: parse error at char 5",
+		},
+		{
+			name:   "not JSON",
+			status: http.StatusBadGateway,
+			body:   `bad gateway`,
+			err:    "status 502",
+		},
+		{
+			name:   "invalid value",
+			status: http.StatusOK,
+			body:   `{"status":"success","data":{"resultType":"scalar","result":[1614556800,"high"]}}`,
+			err:    "invalid Prometheus sample value high",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query string
+			ts := newTestServer(t, tt.status, tt.body, &query)
+			client, err := NewClient(ts.URL, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			value, err := client.Query(`sum(rate(http_requests_total{code="500"}[1m]))`)
+			if query != `sum(rate(http_requests_total{code="500"}[1m]))` {
+				t.Errorf("expected the query to be sent, got %q", query)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != tt.value {
+				t.Errorf("expected %g, got %g", tt.value, value)
+			}
+		})
+	}
+}
+
+func TestClient_QueryNoValues(t *testing.T) {
+	ts := newTestServer(t, http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[]}}`, nil)
+	client, err := NewClient(ts.URL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Query("up"); !errors.Is(err, ErrNoValuesFound) {
+		t.Errorf("expected ErrNoValuesFound, got %v", err)
+	}
+}
+
+func TestClient_QueryTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(ts.URL, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Query("up"); err == nil {
+		t.Error("expected a timeout error")
+	}
+}
+
+func TestClient_QueryPathPrefix(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/prometheus/api/v1/query" {
+			t.Errorf("expected path /prometheus/api/v1/query, got %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"scalar","result":[1614556800,"1"]}}`))
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(ts.URL+"/prometheus/", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Query("up"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewClient_InvalidAddress(t *testing.T) {
+	for _, address := range []string{"", "prometheus:9090", "ftp://prometheus:9090", "http://"} {
+		if _, err := NewClient(address, time.Second); err == nil {
+			t.Errorf("expected an error for address %q", address)
+		}
+	}
+}
@@ -3,14 +3,18 @@ package server
 import (
 	"context"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
 	"go.uber.org/zap"
 	"net/http"
 	"time"
 )
 
-func ListenAndServe(port string, timeout time.Duration, logger *zap.SugaredLogger, stopCh <-chan struct{})  {
+// ListenAndServe serves the metrics, the health check and the notifications dropped
+// by the notifier client into the dead letter queue until stopCh is closed
+func ListenAndServe(port string, timeout time.Duration, deadLetters *notifier.DeadLetterQueue, logger *zap.SugaredLogger, stopCh <-chan struct{})  {
 	mux :=http.DefaultServeMux
 	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/notifications/dead-letters", deadLetters)
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
@@ -0,0 +1,177 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"go.uber.org/zap"
+	"io/ioutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"net/http"
+)
+
+// maxRequestBytes bounds the size of the AdmissionReview read from the API server
+const maxRequestBytes = 3 * 1024 * 1024
+
+// admitFunc reviews the canary, old is the previous version on updates and nil otherwise
+type admitFunc func(cd, old *examplev1beta1.Canary) *admissionv1.AdmissionResponse
+
+// NewValidatingHandler rejects canaries with invalid spec fields
+func NewValidatingHandler(logger *zap.SugaredLogger) http.Handler {
+	return &admissionHandler{admit: validate, logger: logger}
+}
+
+// NewMutatingHandler patches canaries with the spec defaults
+func NewMutatingHandler(logger *zap.SugaredLogger) http.Handler {
+	return &admissionHandler{admit: mutate, logger: logger}
+}
+
+type admissionHandler struct {
+	admit  admitFunc
+	logger *zap.SugaredLogger
+}
+
+func (h *admissionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+		http.Error(w, fmt.Sprintf("unsupported content type %s", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("reading request body failed: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, &review); err != nil {
+		http.Error(w, fmt.Sprintf("decoding admission review failed: %v", err), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "admission review has no request", http.StatusBadRequest)
+		return
+	}
+
+	var response *admissionv1.AdmissionResponse
+	cd := &examplev1beta1.Canary{}
+	var old *examplev1beta1.Canary
+	if len(review.Request.Object.Raw) == 0 {
+		// nothing to check on delete
+		response = &admissionv1.AdmissionResponse{Allowed: true}
+	} else if err := json.Unmarshal(review.Request.Object.Raw, cd); err != nil {
+		response = badRequest(fmt.Sprintf("decoding canary failed: %v", err))
+	} else if len(review.Request.OldObject.Raw) > 0 {
+		old = &examplev1beta1.Canary{}
+		if err := json.Unmarshal(review.Request.OldObject.Raw, old); err != nil {
+			response = badRequest(fmt.Sprintf("decoding old canary failed: %v", err))
+		}
+	}
+	if response == nil {
+		if cd.DeletionTimestamp != nil {
+			// the finalizer has to be removable whatever the spec holds
+			response = &admissionv1.AdmissionResponse{Allowed: true}
+		} else {
+			response = h.admit(cd, old)
+		}
+	}
+	response.UID = review.Request.UID
+
+	out, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionv1.SchemeGroupVersion.String(),
+			Kind:       "AdmissionReview",
+		},
+		Response: response,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("encoding admission review failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if !response.Allowed {
+		h.logger.Infof("Admission of canary %s.%s denied: %s",
+			review.Request.Name, review.Request.Namespace, response.Result.Message)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// validate rejects invalid canaries, an update is only rejected for errors
+// that the previous version did not have so that canaries created before a
+// rule was added can still be updated by the controller
+func validate(cd, old *examplev1beta1.Canary) *admissionv1.AdmissionResponse {
+	errs := ValidateCanary(cd)
+	if old != nil {
+		errs = newErrors(errs, ValidateCanary(old))
+	}
+	if len(errs) > 0 {
+		status := apierrors.NewInvalid(examplev1beta1.Kind("Canary"), cd.Name, errs).ErrStatus
+		return &admissionv1.AdmissionResponse{Result: &status}
+	}
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+func mutate(cd, old *examplev1beta1.Canary) *admissionv1.AdmissionResponse {
+	defaulted := cd.DeepCopy()
+	SetCanaryDefaults(defaulted)
+	if equality.Semantic.DeepEqual(cd.Spec, defaulted.Spec) {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "replace", "path": "/spec", "value": defaulted.Spec},
+	})
+	if err != nil {
+		return &admissionv1.AdmissionResponse{
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusInternalServerError,
+				Reason:  metav1.StatusReasonInternalError,
+				Message: fmt.Sprintf("encoding patch failed: %v", err),
+			},
+		}
+	}
+
+	patchType := admissionv1.PatchTypeJSONPatch
+	return &admissionv1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patch,
+		PatchType: &patchType,
+	}
+}
+
+// newErrors returns the errors that are not in the previous errors
+func newErrors(errs, previous field.ErrorList) field.ErrorList {
+	seen := map[string]bool{}
+	for _, err := range previous {
+		seen[err.Error()] = true
+	}
+	var result field.ErrorList
+	for _, err := range errs {
+		if !seen[err.Error()] {
+			result = append(result, err)
+		}
+	}
+	return result
+}
+
+func badRequest(message string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusBadRequest,
+			Reason:  metav1.StatusReasonBadRequest,
+			Message: message,
+		},
+	}
+}
@@ -0,0 +1,339 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCanary() *examplev1beta1.Canary {
+	return &examplev1beta1.Canary{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "example.app/v1beta1", Kind: "Canary"},
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "test"},
+		Spec: examplev1beta1.CanarySpec{
+			Image:    "stefanprodan/podinfo:3.1.0",
+			Cron:     "*/5 * * * *",
+			Replicas: 1,
+		},
+	}
+}
+
+func rawObject(t *testing.T, cd *examplev1beta1.Canary) runtime.RawExtension {
+	if cd == nil {
+		return runtime.RawExtension{}
+	}
+	raw, err := json.Marshal(cd)
+	if err != nil {
+		t.Fatalf("encoding canary failed: %v", err)
+	}
+	return runtime.RawExtension{Raw: raw}
+}
+
+func postReview(t *testing.T, handler http.Handler, op admissionv1.Operation, cd, old *examplev1beta1.Canary) *admissionv1.AdmissionResponse {
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	body, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "test-uid",
+			Operation: op,
+			Object:    rawObject(t, cd),
+			OldObject: rawObject(t, old),
+		},
+	})
+	if err != nil {
+		t.Fatalf("encoding review failed: %v", err)
+	}
+	res, err := http.Post(ts.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("posting review failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(res.Body).Decode(&review); err != nil {
+		t.Fatalf("decoding review failed: %v", err)
+	}
+	if review.Response == nil {
+		t.Fatal("review has no response")
+	}
+	if review.Response.UID != "test-uid" {
+		t.Errorf("expected UID test-uid, got %s", review.Response.UID)
+	}
+	return review.Response
+}
+
+func TestValidatingHandler(t *testing.T) {
+	handler := NewValidatingHandler(zap.NewNop().Sugar())
+
+	tests := []struct {
+		name    string
+		modify  func(cd *examplev1beta1.Canary)
+		allowed bool
+		fields  []string
+	}{
+		{
+			name:    "valid canary",
+			modify:  func(cd *examplev1beta1.Canary) {},
+			allowed: true,
+		},
+		{
+			name:   "image without tag",
+			modify: func(cd *examplev1beta1.Canary) { cd.Spec.Image = "stefanprodan/podinfo" },
+			fields: []string{"spec.image"},
+		},
+		{
+			name:    "registry port is not a tag",
+			modify:  func(cd *examplev1beta1.Canary) { cd.Spec.Image = "registry:5000/podinfo:3.1.0" },
+			allowed: true,
+		},
+		{
+			name: "invalid cron and replicas",
+			modify: func(cd *examplev1beta1.Canary) {
+				cd.Spec.Cron = "* *"
+				cd.Spec.Replicas = -1
+			},
+			fields: []string{"spec.cron", "spec.replicas"},
+		},
+		{
+			name: "steps without targetRef",
+			modify: func(cd *examplev1beta1.Canary) {
+				cd.Spec.Steps = []int32{10, 50}
+			},
+			fields: []string{"spec.steps"},
+		},
+		{
+			name: "unknown gate",
+			modify: func(cd *examplev1beta1.Canary) {
+				cd.Spec.TargetRef = &examplev1beta1.CanaryTargetReference{Name: "podinfo"}
+				cd.Annotations = map[string]string{examplev1beta1.CanaryGateAnnotation: "skip"}
+			},
+			fields: []string{"metadata.annotations"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cd := newTestCanary()
+			tt.modify(cd)
+			response := postReview(t, handler, admissionv1.Create, cd, nil)
+
+			if response.Allowed != tt.allowed {
+				t.Fatalf("expected allowed %v, got %v: %+v", tt.allowed, response.Allowed, response.Result)
+			}
+			if tt.allowed {
+				return
+			}
+			if response.Result == nil || response.Result.Reason != metav1.StatusReasonInvalid {
+				t.Fatalf("expected an Invalid status, got %+v", response.Result)
+			}
+			if response.Result.Details == nil || len(response.Result.Details.Causes) != len(tt.fields) {
+				t.Fatalf("expected %d causes, got %+v", len(tt.fields), response.Result.Details)
+			}
+			for i, cause := range response.Result.Details.Causes {
+				if !strings.HasPrefix(cause.Field, tt.fields[i]) {
+					t.Errorf("expected cause field %s, got %s", tt.fields[i], cause.Field)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatingHandlerUpdate(t *testing.T) {
+	handler := NewValidatingHandler(zap.NewNop().Sugar())
+
+	old := newTestCanary()
+	old.Spec.Image = "stefanprodan/podinfo"
+
+	// the controller updates metadata of canaries created before the image rule
+	cd := old.DeepCopy()
+	cd.Finalizers = []string{"example.app/finalizer"}
+	if response := postReview(t, handler, admissionv1.Update, cd, old); !response.Allowed {
+		t.Errorf("expected an unchanged invalid field to be allowed, got %+v", response.Result)
+	}
+
+	cd = old.DeepCopy()
+	cd.Spec.Replicas = -1
+	response := postReview(t, handler, admissionv1.Update, cd, old)
+	if response.Allowed {
+		t.Fatal("expected a changed invalid field to be denied")
+	}
+	if causes := response.Result.Details.Causes; len(causes) != 1 || causes[0].Field != "spec.replicas" {
+		t.Errorf("expected a single spec.replicas cause, got %+v", causes)
+	}
+
+	cd = old.DeepCopy()
+	cd.Spec.Image = "other/podinfo"
+	if response := postReview(t, handler, admissionv1.Update, cd, old); response.Allowed {
+		t.Error("expected a changed invalid image to be denied")
+	}
+
+	cd = old.DeepCopy()
+	cd.Spec.Replicas = -1
+	now := metav1.Now()
+	cd.DeletionTimestamp = &now
+	if response := postReview(t, handler, admissionv1.Update, cd, old); !response.Allowed {
+		t.Errorf("expected a deleting canary to be allowed, got %+v", response.Result)
+	}
+}
+
+func TestValidatingHandlerEmptyObject(t *testing.T) {
+	handler := NewValidatingHandler(zap.NewNop().Sugar())
+
+	if response := postReview(t, handler, admissionv1.Delete, nil, nil); !response.Allowed {
+		t.Errorf("expected an empty object to be allowed, got %+v", response.Result)
+	}
+}
+
+func TestMutatingHandler(t *testing.T) {
+	handler := NewMutatingHandler(zap.NewNop().Sugar())
+
+	response := postReview(t, handler, admissionv1.Create, newTestCanary(), nil)
+	if !response.Allowed || response.Patch != nil {
+		t.Errorf("expected no patch for a defaulted canary, got %s", response.Patch)
+	}
+
+	cd := newTestCanary()
+	cd.Spec.Image = " stefanprodan/podinfo:3.1.0 "
+	cd.Spec.Cron = "*/5  *   * * *"
+	cd.Spec.Notifications = &examplev1beta1.CanaryNotifications{Provider: "slack", Channel: "ops"}
+	response = postReview(t, handler, admissionv1.Create, cd, nil)
+	if !response.Allowed {
+		t.Fatalf("expected the canary to be allowed, got %+v", response.Result)
+	}
+	if response.PatchType == nil || *response.PatchType != admissionv1.PatchTypeJSONPatch {
+		t.Fatalf("expected a JSONPatch, got %v", response.PatchType)
+	}
+
+	var patch []struct {
+		Op    string                    `json:"op"`
+		Path  string                    `json:"path"`
+		Value examplev1beta1.CanarySpec `json:"value"`
+	}
+	if err := json.Unmarshal(response.Patch, &patch); err != nil {
+		t.Fatalf("decoding patch failed: %v", err)
+	}
+	if len(patch) != 1 || patch[0].Op != "replace" || patch[0].Path != "/spec" {
+		t.Fatalf("expected a single /spec replace, got %s", response.Patch)
+	}
+	spec := patch[0].Value
+	if spec.Image != "stefanprodan/podinfo:3.1.0" {
+		t.Errorf("expected a trimmed image, got %q", spec.Image)
+	}
+	if spec.Cron != "*/5 * * * *" {
+		t.Errorf("expected a normalized cron, got %q", spec.Cron)
+	}
+	if spec.Notifications.Username != "example" || spec.Notifications.MinSeverity != "info" {
+		t.Errorf("expected notification defaults, got %+v", spec.Notifications)
+	}
+}
+
+func TestAdmissionHandlerBadRequests(t *testing.T) {
+	ts := httptest.NewServer(NewValidatingHandler(zap.NewNop().Sugar()))
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"wrong method", http.MethodGet, "application/json", "", http.StatusMethodNotAllowed},
+		{"wrong content type", http.MethodPost, "text/plain", "{}", http.StatusUnsupportedMediaType},
+		{"invalid JSON", http.MethodPost, "application/json", "{", http.StatusBadRequest},
+		{"no request", http.MethodPost, "application/json", `{"kind":"AdmissionReview"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestAdmissionHandlerInvalidObject(t *testing.T) {
+	ts := httptest.NewServer(NewValidatingHandler(zap.NewNop().Sugar()))
+	defer ts.Close()
+
+	body := `{"kind":"AdmissionReview","request":{"uid":"test-uid","object":{"spec":{"replicas":"one"}}}}`
+	res, err := http.Post(ts.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(res.Body).Decode(&review); err != nil {
+		t.Fatal(err)
+	}
+	if review.Response.Allowed || review.Response.Result.Code != http.StatusBadRequest {
+		t.Errorf("expected a 400 denial, got %+v", review.Response.Result)
+	}
+}
+
+func TestSetCanaryDefaults_Rollout(t *testing.T) {
+	cd := newTestCanary()
+	SetCanaryDefaults(cd)
+	if cd.Spec.Steps != nil || cd.Spec.Interval != nil || cd.Spec.Analysis != nil {
+		t.Errorf("expected no rollout defaults without a target, got %+v", cd.Spec)
+	}
+
+	cd.Spec.TargetRef = &examplev1beta1.CanaryTargetReference{Name: "podinfo"}
+	cd.Spec.Analysis = &examplev1beta1.CanaryAnalysis{Threshold: 5}
+	cd.Spec.Webhooks = []examplev1beta1.CanaryWebhook{
+		{Name: "load-test", Type: examplev1beta1.CanaryWebhookRollout, URL: "http://tester/"},
+		{Name: "gate", Type: examplev1beta1.CanaryWebhookConfirmPromotion, URL: "http://gate/", Timeout: &metav1.Duration{Duration: time.Minute}},
+	}
+	SetCanaryDefaults(cd)
+
+	spec := cd.Spec
+	if len(spec.Steps) != 1 || spec.Steps[0] != 100 {
+		t.Errorf("expected a single step to 100%%, got %v", spec.Steps)
+	}
+	if spec.Interval == nil || spec.Interval.Duration != time.Minute {
+		t.Errorf("expected the default interval, got %v", spec.Interval)
+	}
+	if spec.Analysis.Interval == nil || spec.Analysis.Interval.Duration != 10*time.Second || spec.Analysis.Threshold != 5 {
+		t.Errorf("expected the analysis interval to be defaulted and the threshold kept, got %+v", spec.Analysis)
+	}
+	if spec.Webhooks[0].Timeout == nil || spec.Webhooks[0].Timeout.Duration != 10*time.Second {
+		t.Errorf("expected the default webhook timeout, got %v", spec.Webhooks[0].Timeout)
+	}
+	if spec.Webhooks[1].Timeout.Duration != time.Minute {
+		t.Errorf("expected the webhook timeout to be kept, got %v", spec.Webhooks[1].Timeout)
+	}
+
+	// the defaulted canary is left unchanged
+	defaulted := cd.DeepCopy()
+	SetCanaryDefaults(defaulted)
+	if !reflect.DeepEqual(cd, defaulted) {
+		t.Errorf("expected the defaults to be idempotent, got %+v", defaulted.Spec)
+	}
+}
@@ -0,0 +1,39 @@
+package webhook
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+func ListenAndServeTLS(port, certFile, keyFile string, timeout time.Duration, logger *zap.SugaredLogger, stopCh <-chan struct{}) {
+	mux := http.NewServeMux()
+	mux.Handle("/validate-canary", NewValidatingHandler(logger))
+	mux.Handle("/mutate-canary", NewMutatingHandler(logger))
+	srv := &http.Server{
+		Addr:         ":" + port,
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		IdleTimeout:  15 * time.Second,
+	}
+	logger.Infof("Starting admission webhook server on port %s", port)
+
+	// run server in background
+	go func() {
+		if err := srv.ListenAndServeTLS(certFile, keyFile); err != http.ErrServerClosed {
+			logger.Fatalf("Admission webhook server crashed %v", err)
+		}
+	}()
+
+	<-stopCh
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Errorf("Admission webhook server graceful shutdown failed %v", err)
+	} else {
+		logger.Info("Admission webhook server stopped")
+	}
+}
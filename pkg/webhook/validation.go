@@ -0,0 +1,259 @@
+package webhook
+
+import (
+	examplev1beta1 "github.com/zhouzhihu/k8s-example-crd/pkg/apis/example/v1beta1"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/cron"
+	"github.com/zhouzhihu/k8s-example-crd/pkg/notifier"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// ValidateCanary checks the canary spec and returns an error for each invalid field
+func ValidateCanary(cd *examplev1beta1.Canary) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if cd.Spec.Image == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("image"), "image is required"))
+	} else if !hasTagOrDigest(cd.Spec.Image) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("image"), cd.Spec.Image, "image must include a tag or a digest"))
+	}
+
+	if _, err := cron.Parse(cd.Spec.Cron); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("cron"), cd.Spec.Cron, err.Error()))
+	}
+
+	if cd.Spec.Replicas < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("replicas"), cd.Spec.Replicas, "must be greater than or equal to 0"))
+	}
+
+	allErrs = append(allErrs, validateRollout(cd, specPath)...)
+
+	if gate, ok := cd.Annotations[examplev1beta1.CanaryGateAnnotation]; ok {
+		path := field.NewPath("metadata", "annotations").Key(examplev1beta1.CanaryGateAnnotation)
+		gates := []string{examplev1beta1.CanaryGateHold, examplev1beta1.CanaryGateApprove, examplev1beta1.CanaryGateAbort}
+		if !containsString(gates, gate) {
+			allErrs = append(allErrs, field.NotSupported(path, gate, gates))
+		} else if cd.Spec.TargetRef == nil {
+			allErrs = append(allErrs, field.Forbidden(path, "the gate requires a targetRef"))
+		}
+	}
+
+	if cd.Spec.Analysis != nil {
+		allErrs = append(allErrs, validateAnalysis(cd.Spec.Analysis, specPath.Child("analysis"))...)
+	}
+
+	if cd.Spec.Notifications != nil {
+		allErrs = append(allErrs, validateNotifications(cd.Spec.Notifications, specPath.Child("notifications"))...)
+	}
+
+	return allErrs
+}
+
+// validateRollout checks the progressive rollout settings, the steps must increase up to 100%
+// and the metrics need a query and a threshold range
+func validateRollout(cd *examplev1beta1.Canary, specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if cd.Spec.TargetRef == nil {
+		if len(cd.Spec.Steps) > 0 {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("steps"), "steps require a targetRef"))
+		}
+		if cd.Spec.Interval != nil {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("interval"), "interval requires a targetRef"))
+		}
+		if len(cd.Spec.Metrics) > 0 {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("metrics"), "metrics require a targetRef"))
+		}
+		if len(cd.Spec.Webhooks) > 0 {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("webhooks"), "webhooks require a targetRef"))
+		}
+		return allErrs
+	}
+
+	for _, msg := range validation.IsDNS1123Subdomain(cd.Spec.TargetRef.Name) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("targetRef", "name"), cd.Spec.TargetRef.Name, msg))
+	}
+
+	var previous int32
+	for i, weight := range cd.Spec.Steps {
+		if weight <= previous || weight > 100 {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("steps").Index(i), weight,
+				"steps must be increasing percentages between 1 and 100"))
+		}
+		previous = weight
+	}
+
+	if cd.Spec.Interval != nil && cd.Spec.Interval.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("interval"), cd.Spec.Interval.Duration.String(), "must not be negative"))
+	}
+
+	names := map[string]bool{}
+	for i, metric := range cd.Spec.Metrics {
+		path := specPath.Child("metrics").Index(i)
+		if metric.Name == "" {
+			allErrs = append(allErrs, field.Required(path.Child("name"), "name is required"))
+		} else if names[metric.Name] {
+			allErrs = append(allErrs, field.Duplicate(path.Child("name"), metric.Name))
+		}
+		names[metric.Name] = true
+
+		if strings.TrimSpace(metric.Query) == "" {
+			allErrs = append(allErrs, field.Required(path.Child("query"), "query is required"))
+		} else if _, err := template.New(metric.Name).Parse(metric.Query); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("query"), metric.Query, err.Error()))
+		}
+
+		r := metric.ThresholdRange
+		if r.Min == nil && r.Max == nil {
+			allErrs = append(allErrs, field.Required(path.Child("thresholdRange"), "min or max is required"))
+		}
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			allErrs = append(allErrs, field.Invalid(path.Child("thresholdRange", "min"), *r.Min, "must not be greater than max"))
+		}
+	}
+
+	allErrs = append(allErrs, validateWebhooks(cd.Spec.Webhooks, specPath.Child("webhooks"))...)
+
+	return allErrs
+}
+
+var webhookTypes = []string{
+	string(examplev1beta1.CanaryWebhookPreRollout),
+	string(examplev1beta1.CanaryWebhookRollout),
+	string(examplev1beta1.CanaryWebhookConfirmPromotion),
+	string(examplev1beta1.CanaryWebhookPostRollout),
+	string(examplev1beta1.CanaryWebhookRollback),
+}
+
+func validateWebhooks(hooks []examplev1beta1.CanaryWebhook, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	names := map[string]bool{}
+	for i, hook := range hooks {
+		hookPath := path.Index(i)
+		if hook.Name == "" {
+			allErrs = append(allErrs, field.Required(hookPath.Child("name"), "name is required"))
+		} else if names[hook.Name] {
+			allErrs = append(allErrs, field.Duplicate(hookPath.Child("name"), hook.Name))
+		}
+		names[hook.Name] = true
+
+		if !containsString(webhookTypes, string(hook.Type)) {
+			allErrs = append(allErrs, field.NotSupported(hookPath.Child("type"), hook.Type, webhookTypes))
+		}
+		if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			allErrs = append(allErrs, field.Invalid(hookPath.Child("url"), hook.URL, "must be an http or https URL"))
+		}
+		if hook.Timeout != nil && hook.Timeout.Duration <= 0 {
+			allErrs = append(allErrs, field.Invalid(hookPath.Child("timeout"), hook.Timeout.Duration.String(), "must be greater than 0"))
+		}
+	}
+
+	return allErrs
+}
+
+func validateAnalysis(spec *examplev1beta1.CanaryAnalysis, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if spec.Interval != nil && spec.Interval.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("interval"), spec.Interval.Duration.String(), "must be greater than 0"))
+	}
+	if spec.Threshold < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("threshold"), spec.Threshold, "must be greater than or equal to 0"))
+	}
+
+	return allErrs
+}
+
+var notificationProviders = []string{"slack", "rocket", "msteams", "discord", "generic"}
+
+func validateNotifications(spec *examplev1beta1.CanaryNotifications, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	switch spec.Provider {
+	case "slack", "rocket":
+		if spec.Channel == "" {
+			allErrs = append(allErrs, field.Required(path.Child("channel"), "channel is required by "+spec.Provider))
+		}
+	case "msteams", "discord", "generic":
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("provider"), spec.Provider, notificationProviders))
+	}
+
+	if spec.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(path.Child("secretRef", "name"), "secret name is required"))
+	}
+	if spec.SecretRef.Key == "" {
+		allErrs = append(allErrs, field.Required(path.Child("secretRef", "key"), "secret key is required"))
+	}
+
+	if spec.MinSeverity != "" && !notifier.IsValidSeverity(spec.MinSeverity) {
+		allErrs = append(allErrs, field.NotSupported(path.Child("minSeverity"), spec.MinSeverity,
+			[]string{notifier.SeverityInfo, notifier.SeverityWarn, notifier.SeverityError}))
+	}
+
+	return allErrs
+}
+
+// SetCanaryDefaults fills the optional spec fields and normalizes the user input,
+// the rollout settings are only set on the canaries with a target
+func SetCanaryDefaults(cd *examplev1beta1.Canary) {
+	cd.Spec.Image = strings.TrimSpace(cd.Spec.Image)
+	cd.Spec.Cron = strings.Join(strings.Fields(cd.Spec.Cron), " ")
+
+	if cd.Spec.TargetRef != nil {
+		if len(cd.Spec.Steps) == 0 {
+			cd.Spec.Steps = []int32{examplev1beta1.DefaultStepWeight}
+		}
+		if cd.Spec.Interval == nil {
+			cd.Spec.Interval = &metav1.Duration{Duration: examplev1beta1.DefaultStepInterval}
+		}
+		if cd.Spec.Analysis == nil {
+			cd.Spec.Analysis = &examplev1beta1.CanaryAnalysis{}
+		}
+		if cd.Spec.Analysis.Interval == nil {
+			cd.Spec.Analysis.Interval = &metav1.Duration{Duration: examplev1beta1.DefaultAnalysisInterval}
+		}
+		if cd.Spec.Analysis.Threshold == 0 {
+			cd.Spec.Analysis.Threshold = examplev1beta1.DefaultFailureThreshold
+		}
+		for i := range cd.Spec.Webhooks {
+			if cd.Spec.Webhooks[i].Timeout == nil {
+				cd.Spec.Webhooks[i].Timeout = &metav1.Duration{Duration: examplev1beta1.DefaultWebhookTimeout}
+			}
+		}
+	}
+
+	if n := cd.Spec.Notifications; n != nil {
+		if n.Username == "" {
+			n.Username = "example"
+		}
+		if n.MinSeverity == "" {
+			n.MinSeverity = notifier.SeverityInfo
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTagOrDigest looks for a tag after the last path component
+// so that registry ports like registry:5000/app are not mistaken for a tag
+func hasTagOrDigest(image string) bool {
+	if strings.Contains(image, "@") {
+		return true
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	return strings.Contains(name, ":")
+}